@@ -22,6 +22,26 @@ type ClientOptions struct {
 	MaxRequestTimeout time.Duration
 	Headers           http.Header
 	Middlewares       []RequestMiddleware
+	// PropagateDeadline sends the remaining time until the context deadline in the HeaderRequestTimeout header.
+	// Only enable this for services which understand the header, see NewDeadlineMiddleware.
+	PropagateDeadline bool
+	// RateLimit limits the rate of requests sent by the client, and its clones, using a token bucket.
+	// Disabled if RateLimit.Limit is 0.
+	RateLimit Rate
+	// MaxConcurrentRequests limits the number of requests in flight, including reading the response. Unlimited if 0.
+	MaxConcurrentRequests int
+	// FailFast returns ErrThrottled instead of waiting for the rate limit or a concurrency slot.
+	// Otherwise requests wait until the context is done.
+	FailFast bool
+	// OnThrottle is called with the time a request waited for the rate limit or a concurrency slot, whenever it had
+	// to wait or was rejected.
+	OnThrottle func(req *http.Request, wait time.Duration)
+	// Hedging policy for requests with hedging enabled, see Requester.WithHedging.
+	Hedging HedgePolicy
+	// Logging writes a record for each request, see ClientLogger.
+	Logging ClientLogger
+	// Interceptors wrap the sending of every request, see Client.Use.
+	Interceptors []Interceptor
 }
 
 func (options ClientOptions) AddHeaders(headers map[string]string) ClientOptions {
@@ -55,6 +75,12 @@ type Client struct {
 	defaultTimeout time.Duration
 	dheaders       http.Header
 	middlewares    []RequestMiddleware
+	deadline       bool
+	limits         *clientLimits
+	hedger         *hedger
+	balancer       *balancer
+	logger         *clientLogger
+	interceptors   []Interceptor
 }
 
 // NewClient creates a new Requester for a specific host
@@ -73,6 +99,11 @@ func NewCustomClient(host string, options ClientOptions) *Client {
 		defaultTimeout: options.MaxRequestTimeout,
 		dheaders:       options.Headers,
 		middlewares:    options.Middlewares,
+		deadline:       options.PropagateDeadline,
+		limits:         newClientLimits(options),
+		hedger:         newHedger(options.Hedging),
+		logger:         newClientLogger(options.Logging),
+		interceptors:   options.Interceptors,
 	}
 	client.dheaders.Add("User-Agent", userAgent())
 
@@ -93,22 +124,58 @@ type ClientStatusReport struct {
 	Addresses    []net.IP      `json:"addresses,omitempty"`
 	Error        string        `json:"error,omitempty"`
 	PingDuration time.Duration `json:"elapsed_ns"`
+	// Endpoints of balanced clients, by URL and the address connected to, if any.
+	Endpoints map[string]ClientStatusReport `json:"endpoints,omitempty"`
 }
 
 // Ping returns a status report for the client's connection.
+// Balanced clients ping every endpoint and only report an error if all endpoints fail.
 func (cli Client) Ping() ClientStatusReport {
+	if cli.balancer == nil {
+		return ping(cli.conn, cli.host, "")
+	}
+	var start = time.Now()
+	var rep = ClientStatusReport{Endpoints: make(map[string]ClientStatusReport)}
+	var failed int
+	for _, ep := range cli.balancer.snapshot() {
+		rep.Endpoints[ep.name()] = ep.ping(cli.conn)
+		if rep.Endpoints[ep.name()].Error != "" {
+			failed++
+		}
+	}
+	if failed == len(rep.Endpoints) {
+		rep.Error = errNoEndpoints.Error()
+	}
+	rep.PingDuration = time.Since(start)
+	return rep
+}
+
+// ping the host, looking up the IPs of the host of the address instead if set.
+func ping(conn *http.Client, host, address string) ClientStatusReport {
 	var start = time.Now()
-	var _, err = cli.conn.Get(cli.host)
+	var res *http.Response
+	var req, err = http.NewRequest(http.MethodGet, host, nil)
+	if err == nil {
+		res, err = conn.Do(req)
+	}
 	var rep = ClientStatusReport{PingDuration: time.Since(start)}
 	if err != nil {
 		rep.Error = fmt.Sprintf("%+v", err)
+	} else {
+		res.Body.Close()
 	}
-	u, err := url.Parse(cli.host)
+	u, err := url.Parse(host)
 	if err != nil {
 		rep.Error = fmt.Sprintf("%+v", err)
 		return rep
 	}
-	rep.Addresses, err = net.LookupIP(u.Hostname())
+	var hostname = u.Hostname()
+	if address != "" {
+		if hostname, _, err = net.SplitHostPort(address); err != nil {
+			hostname = address
+		}
+	}
+	rep.Addresses, err = net.LookupIP(hostname)
 	if err != nil {
 		rep.Error = fmt.Sprintf("%+v", err)
 		return rep
@@ -116,6 +183,21 @@ func (cli Client) Ping() ClientStatusReport {
 	return rep
 }
 
+// Endpoints returns the status of the endpoints of a balanced client, nil otherwise.
+func (cli Client) Endpoints() []EndpointStatus {
+	if cli.balancer == nil {
+		return nil
+	}
+	return cli.balancer.status()
+}
+
+// Close stops the background tasks of balanced clients. The client can still be used afterwards.
+func (cli Client) Close() {
+	if cli.balancer != nil {
+		cli.balancer.close()
+	}
+}
+
 func (cli Client) Clone() Client {
 	return Client{
 		host:           cli.host,
@@ -123,10 +205,23 @@ func (cli Client) Clone() Client {
 		defaultTimeout: cli.defaultTimeout,
 		dheaders:       cli.dheaders.Clone(),
 		middlewares:    cli.middlewares,
+		deadline:       cli.deadline,
+		limits:         cli.limits,
+		hedger:         cli.hedger,
+		balancer:       cli.balancer,
+		logger:         cli.logger,
+		interceptors:   cli.interceptors,
 	}
 }
 
+// FullURL of the endpoint. Balanced clients use their first healthy endpoint, without affecting the balancing of
+// requests.
 func (cli Client) FullURL(endpoint string) string {
+	if cli.balancer != nil {
+		if ep := cli.balancer.first(); ep != nil {
+			return combineURL(ep.url, endpoint)
+		}
+	}
 	return combineURL(cli.host, endpoint)
 }
 
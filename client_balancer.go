@@ -0,0 +1,481 @@
+package webservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+var errNoEndpoints = errors.New("no endpoints available")
+
+// BalancingStrategy for picking the endpoint of each request.
+type BalancingStrategy int
+
+const (
+	// RoundRobin picks endpoints in turn.
+	RoundRobin BalancingStrategy = iota
+	// LeastOutstanding picks the endpoint with the fewest requests in flight.
+	LeastOutstanding
+	// Weighted picks endpoints in proportion to their weight, using smooth weighted round-robin.
+	Weighted
+)
+
+// Endpoint is a base URL of a service, e.g. "http://10.0.0.1:8080/api".
+type Endpoint struct {
+	URL string
+	// Address to connect to instead of the host of the URL, e.g. "10.0.0.1:8080". The URL's host is still used for
+	// the Host header and TLS verification. Each address has its own pool of connections. The address is not used when
+	// connecting through a proxy. Only supported by connections created with NewConn or using an *http.Transport,
+	// ignored otherwise.
+	Address string
+	// Weight used by the Weighted strategy. Defaults to 1.
+	Weight int
+}
+
+// EndpointResolver provides the endpoints of a service, e.g. from a service registry or DNS.
+type EndpointResolver interface {
+	ResolveEndpoints(ctx context.Context) ([]Endpoint, error)
+}
+
+// StaticEndpoints is an EndpointResolver for a fixed list of base URLs.
+type StaticEndpoints []string
+
+// ResolveEndpoints returns the base URLs with the default weight.
+func (urls StaticEndpoints) ResolveEndpoints(context.Context) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, len(urls))
+	for i := range urls {
+		endpoints[i] = Endpoint{URL: urls[i]}
+	}
+	return endpoints, nil
+}
+
+// BalancingOptions for clients of multiple endpoints.
+type BalancingOptions struct {
+	// Resolver of the endpoints, e.g. StaticEndpoints.
+	Resolver EndpointResolver
+	// ResolveInterval between refreshing the endpoints. Endpoints are only resolved once if 0.
+	ResolveInterval time.Duration
+	// Strategy for picking endpoints. Defaults to RoundRobin.
+	Strategy BalancingStrategy
+	// MaxFailures is the number of consecutive failures, connection errors or 5xx responses, after which an endpoint is
+	// ejected. Defaults to 5.
+	MaxFailures int
+	// EjectionTime is how long an endpoint is ejected for, unless a health check succeeds first. Defaults to 30s.
+	EjectionTime time.Duration
+	// HealthCheckInterval between pinging all endpoints, ejecting the ones failing and restoring the ones recovered.
+	// Disabled if 0.
+	HealthCheckInterval time.Duration
+	// MaxFailover is the number of other endpoints tried on connection errors. Defaults to 2.
+	// Requests are only retried if they were not sent and their body can be replayed.
+	MaxFailover int
+	// Logger for endpoint changes. Defaults to slog.Default.
+	Logger *slog.Logger
+}
+
+func (options BalancingOptions) sanitize() BalancingOptions {
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = 5
+	}
+	if options.EjectionTime <= 0 {
+		options.EjectionTime = 30 * time.Second
+	}
+	if options.MaxFailover <= 0 {
+		options.MaxFailover = 2
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	return options
+}
+
+// EndpointStatus of an endpoint of a balanced client.
+type EndpointStatus struct {
+	URL         string `json:"url"`
+	Address     string `json:"address,omitempty"`
+	Weight      int    `json:"weight"`
+	Outstanding int    `json:"outstanding"`
+	Failures    int    `json:"failures"`
+	Ejected     bool   `json:"ejected"`
+}
+
+// NewBalancedClient creates a Client which balances requests across the endpoints of a service.
+// The endpoints are resolved before returning. Call Close to stop refreshing endpoints and health checks.
+func NewBalancedClient(balancing BalancingOptions, options ClientOptions) (*Client, error) {
+	if balancing.Resolver == nil {
+		return nil, errors.New("balanced client requires an endpoint resolver")
+	}
+	cli := NewCustomClient("", options)
+	cli.balancer = newBalancer(balancing.sanitize(), cli.conn)
+	if err := cli.balancer.resolve(); err != nil {
+		return nil, err
+	}
+	cli.balancer.start()
+
+	return cli, nil
+}
+
+// balancedEndpoint is an endpoint and its state.
+type balancedEndpoint struct {
+	url          string
+	address      string
+	conn         *http.Client
+	weight       int
+	current      int
+	outstanding  atomic.Int64
+	failures     atomic.Int64
+	ejectedUntil atomic.Int64
+}
+
+func (ep *balancedEndpoint) ejected(now time.Time) bool {
+	return ep.ejectedUntil.Load() > now.UnixNano()
+}
+
+// name identifies the endpoint in logs and reports, e.g. "http://service:8080 (10.0.0.1:8080)".
+func (ep *balancedEndpoint) name() string {
+	if ep.address == "" {
+		return ep.url
+	}
+	return ep.url + " (" + ep.address + ")"
+}
+
+// ping the endpoint using its own connection, if it has one, or the client's.
+func (ep *balancedEndpoint) ping(conn *http.Client) ClientStatusReport {
+	if ep.conn != nil {
+		conn = ep.conn
+	}
+	return ping(conn, ep.url, ep.address)
+}
+
+// context of the requests to the endpoint.
+func (ep *balancedEndpoint) context(ctx context.Context) context.Context {
+	if ep.conn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyEndpointConn{}, ep.conn)
+}
+
+type contextKeyEndpointConn struct{}
+
+// endpointConn returns the connection of the endpoint a request is sent to, see balancedEndpoint.context.
+func endpointConn(req *http.Request) (*http.Client, bool) {
+	conn, ok := req.Context().Value(contextKeyEndpointConn{}).(*http.Client)
+	return conn, ok
+}
+
+type balancer struct {
+	options   BalancingOptions
+	conn      *http.Client
+	log       logger.SLogger
+	mu        sync.Mutex
+	endpoints []*balancedEndpoint
+	next      atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBalancer(options BalancingOptions, conn *http.Client) *balancer {
+	return &balancer{
+		options: options,
+		conn:    conn,
+		log:     logger.NewSLogWrapper(options.Logger).WithTags("http", "balancer"),
+		done:    make(chan struct{}),
+	}
+}
+
+// resolve the endpoints, keeping the state of the ones which did not change.
+func (b *balancer) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resolved, err := b.options.Resolver.ResolveEndpoints(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve endpoints; %w", err)
+	}
+	if len(resolved) == 0 {
+		return errNoEndpoints
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	type key struct{ url, address string }
+	current := make(map[key]*balancedEndpoint, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		current[key{ep.url, ep.address}] = ep
+	}
+	endpoints := make([]*balancedEndpoint, 0, len(resolved))
+	for _, endpoint := range resolved {
+		ep, ok := current[key{endpoint.URL, endpoint.Address}]
+		if !ok {
+			ep = &balancedEndpoint{url: endpoint.URL, address: endpoint.Address}
+			if endpoint.Address != "" {
+				ep.conn = b.endpointConn(endpoint.URL, endpoint.Address)
+			}
+			if b.endpoints != nil {
+				b.log.Infof("endpoint added [url:%s]", ep.name())
+			}
+		}
+		delete(current, key{endpoint.URL, endpoint.Address})
+		ep.weight = max(endpoint.Weight, 1)
+		endpoints = append(endpoints, ep)
+	}
+	for _, ep := range current {
+		b.log.Infof("endpoint removed [url:%s]", ep.name())
+		if ep.conn != nil {
+			ep.conn.CloseIdleConnections()
+		}
+	}
+	b.endpoints = endpoints
+
+	return nil
+}
+
+// endpointConn returns a copy of the connection connecting to the address, nil if the transport does not support it.
+func (b *balancer) endpointConn(endpoint, address string) *http.Client {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil
+	}
+	var transport = b.conn.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	transport, ok := addressTransport(transport, canonicalAddr(&http.Request{URL: u}), address)
+	if !ok {
+		b.log.Warnf("endpoint address not supported by the connection, using the url host [url:%s]", endpoint)
+		return nil
+	}
+	conn := *b.conn
+	conn.Transport = transport
+	return &conn
+}
+
+// start refreshing the endpoints and checking their health.
+func (b *balancer) start() {
+	if b.options.ResolveInterval > 0 {
+		go b.every(b.options.ResolveInterval, func() {
+			if err := b.resolve(); err != nil {
+				b.log.Errorf("failed to refresh endpoints: %v", err)
+			}
+		})
+	}
+	if b.options.HealthCheckInterval > 0 {
+		go b.every(b.options.HealthCheckInterval, b.check)
+	}
+}
+
+func (b *balancer) every(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+func (b *balancer) close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+// check the health of all endpoints.
+func (b *balancer) check() {
+	for _, ep := range b.snapshot() {
+		report := ep.ping(b.conn)
+		switch {
+		case report.Error != "":
+			if !ep.ejected(time.Now()) {
+				b.log.Warnf("endpoint ejected by health check [url:%s]: %s", ep.name(), report.Error)
+			}
+			ep.ejectedUntil.Store(time.Now().Add(b.options.EjectionTime).UnixNano())
+		case ep.ejectedUntil.Load() != 0:
+			b.log.Infof("endpoint restored by health check [url:%s]", ep.name())
+			ep.failures.Store(0)
+			ep.ejectedUntil.Store(0)
+		}
+	}
+}
+
+func (b *balancer) snapshot() []*balancedEndpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.endpoints
+}
+
+// pick an endpoint, excluding the ones already tried. Ejected endpoints are only picked if all endpoints are ejected.
+func (b *balancer) pick(tried []*balancedEndpoint) *balancedEndpoint {
+	var now = time.Now()
+	var candidates, ejected []*balancedEndpoint
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ep := range b.endpoints {
+		switch {
+		case contains(tried, ep):
+		case ep.ejected(now):
+			ejected = append(ejected, ep)
+		default:
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch b.options.Strategy {
+	case LeastOutstanding:
+		offset := int(b.next.Add(1))
+		var best *balancedEndpoint
+		for i := range candidates {
+			ep := candidates[(offset+i)%len(candidates)]
+			if best == nil || ep.outstanding.Load() < best.outstanding.Load() {
+				best = ep
+			}
+		}
+		return best
+	case Weighted:
+		var total int
+		var best *balancedEndpoint
+		for _, ep := range candidates {
+			ep.current += ep.weight
+			total += ep.weight
+			if best == nil || ep.current > best.current {
+				best = ep
+			}
+		}
+		best.current -= total
+		return best
+	default:
+		return candidates[int(b.next.Add(1)-1)%len(candidates)]
+	}
+}
+
+// first returns the first endpoint which is not ejected, or the first endpoint if all are ejected.
+func (b *balancer) first() *balancedEndpoint {
+	var now = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ep := range b.endpoints {
+		if !ep.ejected(now) {
+			return ep
+		}
+	}
+	if len(b.endpoints) > 0 {
+		return b.endpoints[0]
+	}
+	return nil
+}
+
+func contains(endpoints []*balancedEndpoint, ep *balancedEndpoint) bool {
+	for _, e := range endpoints {
+		if e == ep {
+			return true
+		}
+	}
+	return false
+}
+
+// report the outcome of a request, ejecting the endpoint after too many consecutive failures.
+func (b *balancer) report(ep *balancedEndpoint, failed bool) {
+	if !failed {
+		ep.failures.Store(0)
+		return
+	}
+	if ep.failures.Add(1) >= int64(b.options.MaxFailures) && !ep.ejected(time.Now()) {
+		b.log.Warnf("endpoint ejected after %d consecutive failures [url:%s]", ep.failures.Load(), ep.name())
+		ep.ejectedUntil.Store(time.Now().Add(b.options.EjectionTime).UnixNano())
+	}
+}
+
+func (b *balancer) status() []EndpointStatus {
+	var now = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var status = make([]EndpointStatus, len(b.endpoints))
+	for i, ep := range b.endpoints {
+		status[i] = EndpointStatus{
+			URL:         ep.url,
+			Address:     ep.address,
+			Weight:      ep.weight,
+			Outstanding: int(ep.outstanding.Load()),
+			Failures:    int(ep.failures.Load()),
+			Ejected:     ep.ejected(now),
+		}
+	}
+	return status
+}
+
+// isConnectionError reports if the request failed before being sent, so it is safe to send it elsewhere.
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// doBalanced sends the request to an endpoint, failing over to others on connection errors.
+func (req StreamRequester) doBalanced(ctx context.Context, method string, endpoint string, body io.Reader) (int, io.ReadCloser, error) {
+	var b = req.cli.balancer
+	var tried []*balancedEndpoint
+	var getBody func() (io.ReadCloser, error)
+	var lastErr = errNoEndpoints
+	var first = requestAttempt(ctx)
+	for attempt := 0; attempt <= b.options.MaxFailover; attempt++ {
+		ep := b.pick(tried)
+		if ep == nil {
+			break
+		}
+		tried = append(tried, ep)
+		if attempt > 0 && body != nil {
+			if getBody == nil {
+				break
+			}
+			var err error
+			if body, err = getBody(); err != nil {
+				break
+			}
+		}
+
+		hreq, err := req.prepare(ep.context(withAttempt(ctx, first+attempt)), method, combineURL(ep.url, endpoint), body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error creating request; %w", err)
+		}
+		getBody = hreq.GetBody
+
+		ep.outstanding.Add(1)
+		status, res, err := req.send(hreq)
+		if err != nil {
+			ep.outstanding.Add(-1)
+			// cancelled and throttled requests say nothing about the health of the endpoint
+			if ctx.Err() == nil && !errors.Is(err, ErrThrottled) {
+				b.report(ep, true)
+			}
+			lastErr = err
+			if isConnectionError(err) && ctx.Err() == nil {
+				continue
+			}
+			return 0, nil, err
+		}
+		return status, &releaseOnClose{ReadCloser: res, release: func() {
+			ep.outstanding.Add(-1)
+			b.report(ep, status >= http.StatusInternalServerError)
+		}}, nil
+	}
+
+	return 0, nil, lastErr
+}
@@ -0,0 +1,181 @@
+package webservice
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResolver struct {
+	mu        sync.Mutex
+	endpoints []Endpoint
+}
+
+func (r *testResolver) set(endpoints ...Endpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endpoints = endpoints
+}
+
+func (r *testResolver) ResolveEndpoints(context.Context) ([]Endpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.endpoints, nil
+}
+
+// closedAddress returns the URL of an address which refuses connections.
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close()
+	return "http://" + l.Addr().String()
+}
+
+func TestBalancedClient(t *testing.T) {
+	var release = make(chan struct{})
+	var newServer = func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/block" {
+				<-release
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(name + string(body)))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	var a, b = newServer("a"), newServer("b")
+	defer close(release)
+
+	get := func(cli *Client, path string) string {
+		status, body, err := cli.Request(context.Background(), http.MethodGet, path, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		return string(body)
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{a.URL + "/api", b.URL + "/api/"}}, ClientOptions{})
+		require.NoError(t, err)
+		defer cli.Close()
+		assert.Equal(t, []string{"a", "b", "a", "b"}, []string{get(cli, "/x"), get(cli, "x"), get(cli, "/x"), get(cli, "/x")})
+
+		// building URLs does not affect the balancing
+		assert.Equal(t, a.URL+"/api/x", cli.FullURL("/x"))
+		assert.Equal(t, []string{"a", "b"}, []string{get(cli, "/x"), get(cli, "/x")})
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		resolver := &testResolver{}
+		resolver.set(Endpoint{URL: a.URL, Weight: 3}, Endpoint{URL: b.URL})
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: resolver, Strategy: Weighted}, ClientOptions{})
+		require.NoError(t, err)
+		var hits = make(map[string]int)
+		for i := 0; i < 8; i++ {
+			hits[get(cli, "/")]++
+		}
+		assert.Equal(t, map[string]int{"a": 6, "b": 2}, hits)
+	})
+
+	t.Run("least outstanding", func(t *testing.T) {
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{a.URL, b.URL}, Strategy: LeastOutstanding}, ClientOptions{})
+		require.NoError(t, err)
+		go cli.Request(context.Background(), http.MethodGet, "/api/block", nil)
+		require.Eventually(t, func() bool {
+			status := cli.Endpoints()
+			return status[0].Outstanding+status[1].Outstanding == 1
+		}, time.Second, time.Millisecond)
+		busy := "a"
+		if cli.Endpoints()[0].Outstanding == 0 {
+			busy = "b"
+		}
+		for i := 0; i < 4; i++ {
+			assert.NotEqual(t, busy, get(cli, "/"))
+		}
+	})
+
+	t.Run("failover and ejection", func(t *testing.T) {
+		closed := closedAddress(t)
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{closed, a.URL}, MaxFailures: 2}, ClientOptions{})
+		require.NoError(t, err)
+		for i := 0; i < 4; i++ {
+			status, body, err := cli.Request(context.Background(), http.MethodPost, "/", []byte("-body"))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, "a-body", string(body), "body replayed on failover")
+		}
+		assert.Equal(t, []EndpointStatus{
+			{URL: closed, Weight: 1, Failures: 2, Ejected: true},
+			{URL: a.URL, Weight: 1},
+		}, cli.Endpoints())
+
+		cli, err = NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{closed}}, ClientOptions{})
+		require.NoError(t, err)
+		_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.Error(t, err)
+		assert.NotEmpty(t, cli.Ping().Error)
+	})
+
+	t.Run("cancelled requests are not failures", func(t *testing.T) {
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{a.URL}, MaxFailures: 1}, ClientOptions{})
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			require.Eventually(t, func() bool { return cli.Endpoints()[0].Outstanding == 1 }, time.Second, time.Millisecond)
+			cancel()
+		}()
+		_, _, err = cli.Request(ctx, http.MethodGet, "/api/block", nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, []EndpointStatus{{URL: a.URL, Weight: 1}}, cli.Endpoints())
+	})
+
+	t.Run("throttled requests are not failures", func(t *testing.T) {
+		cli, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{a.URL}, MaxFailures: 1}, ClientOptions{
+			RateLimit: Rate{Limit: 1, Period: time.Hour},
+			FailFast:  true,
+		})
+		require.NoError(t, err)
+		get(cli, "/")
+		_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.ErrorIs(t, err, ErrThrottled)
+		assert.Equal(t, []EndpointStatus{{URL: a.URL, Weight: 1}}, cli.Endpoints())
+	})
+
+	t.Run("health checks and refresh", func(t *testing.T) {
+		resolver := &testResolver{}
+		resolver.set(Endpoint{URL: a.URL})
+		cli, err := NewBalancedClient(BalancingOptions{
+			Resolver:            resolver,
+			ResolveInterval:     5 * time.Millisecond,
+			HealthCheckInterval: 5 * time.Millisecond,
+		}, ClientOptions{})
+		require.NoError(t, err)
+		defer cli.Close()
+
+		cli.balancer.snapshot()[0].ejectedUntil.Store(time.Now().Add(time.Hour).UnixNano())
+		assert.Eventually(t, func() bool { return !cli.Endpoints()[0].Ejected }, time.Second, time.Millisecond, "restored")
+
+		closed := closedAddress(t)
+		resolver.set(Endpoint{URL: a.URL}, Endpoint{URL: closed})
+		assert.Eventually(t, func() bool {
+			status := cli.Endpoints()
+			return len(status) == 2 && status[1].Ejected
+		}, time.Second, time.Millisecond, "ejected")
+
+		rep := cli.Ping()
+		assert.Empty(t, rep.Error)
+		assert.Len(t, rep.Endpoints, 2)
+		assert.NotEmpty(t, rep.Endpoints[closed].Error)
+	})
+
+	_, err := NewBalancedClient(BalancingOptions{Resolver: StaticEndpoints{}}, ClientOptions{})
+	assert.ErrorIs(t, err, errNoEndpoints)
+}
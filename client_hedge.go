@@ -0,0 +1,151 @@
+package webservice
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeSamples    = 1000
+	hedgeMinSamples = 20
+	hedgeWindow     = 1000
+)
+
+// HedgePolicy for sending a second attempt of slow requests. Only use hedging for idempotent requests.
+type HedgePolicy struct {
+	// Delay before sending the second attempt. Used until enough latencies are tracked if Percentile is set.
+	Delay time.Duration
+	// Percentile of the latencies tracked by the client used as the delay, between 0 and 1, e.g. 0.95.
+	Percentile float64
+	// Budget is the maximum percentage of requests which are hedged. Defaults to 10.
+	Budget float64
+}
+
+// hedger sends the second attempts of requests with hedging enabled and tracks their latencies.
+type hedger struct {
+	policy    HedgePolicy
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	cached    time.Duration
+	stale     int
+	requests  float64
+	hedges    float64
+}
+
+func newHedger(policy HedgePolicy) *hedger {
+	if policy.Delay <= 0 && policy.Percentile <= 0 {
+		return nil
+	}
+	if policy.Budget <= 0 {
+		policy.Budget = 10
+	}
+	return &hedger{policy: policy}
+}
+
+type attemptResult struct {
+	status   int
+	response []byte
+	err      error
+	elapsed  time.Duration
+}
+
+// do runs the attempt, and a second one if the first is slower than the hedging delay, returning the first successful
+// response. The slower attempt is canceled.
+func (h *hedger) do(ctx context.Context, attempt func(ctx context.Context) (int, []byte, error)) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, 2)
+	run := func(ctx context.Context) {
+		start := time.Now()
+		status, response, err := attempt(ctx)
+		results <- attemptResult{status: status, response: response, err: err, elapsed: time.Since(start)}
+	}
+	go run(ctx)
+	inflight := 1
+
+	var hedge <-chan time.Time
+	if delay, ok := h.begin(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	for {
+		select {
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				h.observe(res.elapsed)
+				return res.status, res.response, nil
+			}
+			if inflight == 0 {
+				return res.status, res.response, res.err
+			}
+		case <-hedge:
+			hedge = nil
+			if h.allow() {
+				go run(withAttempt(ctx, 2))
+				inflight++
+			}
+		}
+	}
+}
+
+// begin accounts for a new request and returns the hedging delay, if any.
+func (h *hedger) begin() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	if h.requests >= hedgeWindow {
+		// decay so the budget follows recent traffic
+		h.requests /= 2
+		h.hedges /= 2
+	}
+	if h.policy.Percentile > 0 && len(h.latencies) >= hedgeMinSamples {
+		return h.percentile(), true
+	}
+	return h.policy.Delay, h.policy.Delay > 0
+}
+
+// allow a hedge if within the budget.
+func (h *hedger) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hedges+1 > h.requests*h.policy.Budget/100 {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+func (h *hedger) observe(elapsed time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeSamples {
+		h.latencies = append(h.latencies, elapsed)
+	} else {
+		h.latencies[h.next] = elapsed
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	h.stale++
+}
+
+// percentile of the tracked latencies, recalculated after every hedgeMinSamples new samples. Must hold the lock.
+func (h *hedger) percentile() time.Duration {
+	if h.cached == 0 || h.stale >= hedgeMinSamples {
+		sorted := slices.Clone(h.latencies)
+		slices.Sort(sorted)
+		i := int(math.Ceil(h.policy.Percentile*float64(len(sorted)))) - 1
+		h.cached = sorted[max(0, min(i, len(sorted)-1))]
+		h.stale = 0
+	}
+	return h.cached
+}
@@ -0,0 +1,81 @@
+package webservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester_WithHedging(t *testing.T) {
+	var calls, canceled atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1)%2 == 1 && r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+				canceled.Add(1)
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	t.Run("hedged after delay", func(t *testing.T) {
+		calls.Store(0)
+		cli := NewCustomClient(srv.URL, ClientOptions{Hedging: HedgePolicy{Delay: 20 * time.Millisecond, Budget: 100}})
+		start := time.Now()
+		status, body, err := cli.NewRequest().WithHedging(true).Do(context.Background(), http.MethodGet, "/slow", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "/slow", string(body))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.Eventually(t, func() bool { return canceled.Load() == 1 }, time.Second, time.Millisecond, "slower attempt canceled")
+	})
+
+	t.Run("not hedged", func(t *testing.T) {
+		calls.Store(0)
+		cli := NewCustomClient(srv.URL, ClientOptions{Hedging: HedgePolicy{Delay: 20 * time.Millisecond, Budget: 100}})
+		_, _, err := cli.NewRequest().WithHedging(true).Do(context.Background(), http.MethodGet, "/fast", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), calls.Load(), "fast responses are not hedged")
+
+		calls.Store(0)
+		_, _, err = cli.NewRequest().Do(context.Background(), http.MethodGet, "/slow", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), calls.Load(), "hedging disabled")
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		h := newHedger(HedgePolicy{Delay: time.Millisecond})
+		var hedged int
+		for i := 0; i < 100; i++ {
+			h.begin()
+			if h.allow() {
+				hedged++
+			}
+		}
+		assert.Equal(t, 10, hedged)
+	})
+
+	t.Run("percentile", func(t *testing.T) {
+		h := newHedger(HedgePolicy{Percentile: 0.9})
+		_, ok := h.begin()
+		assert.False(t, ok, "no delay until enough latencies are tracked")
+		for i := 1; i <= 100; i++ {
+			h.observe(time.Duration(i) * time.Millisecond)
+		}
+		delay, ok := h.begin()
+		assert.True(t, ok)
+		assert.Equal(t, 90*time.Millisecond, delay)
+	})
+
+	assert.Nil(t, newHedger(HedgePolicy{}))
+}
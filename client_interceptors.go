@@ -0,0 +1,161 @@
+package webservice
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RequestHandler sends a request and returns its response, e.g. http.Client.Do.
+type RequestHandler func(req *http.Request) (*http.Response, error)
+
+// Interceptor wraps the sending of requests by a Client, being able to change the request, observe or replace the
+// response, retry or short-circuit the request. Interceptors must close the bodies of responses they discard.
+type Interceptor func(next RequestHandler) RequestHandler
+
+// Use adds interceptors to the client. The first interceptor is the outermost one, running before the others.
+// Interceptors run around every request sent, before the client limits are applied and the request is logged, so
+// each request sent by an interceptor, e.g. each retry, goes through the limits and is logged again.
+func (cli *Client) Use(interceptors ...Interceptor) *Client {
+	cli.interceptors = append(slices.Clip(cli.interceptors), interceptors...)
+	return cli
+}
+
+// handler builds the interceptor chain around the connection.
+func (cli Client) handler() RequestHandler {
+	var handler RequestHandler = func(req *http.Request) (*http.Response, error) {
+		if conn, ok := endpointConn(req); ok {
+			return conn.Do(req)
+		}
+		return cli.conn.Do(req)
+	}
+	if cli.logger != nil {
+		handler = cli.logger.intercept(handler)
+	}
+	if cli.limits != nil {
+		handler = cli.limits.intercept(handler)
+	}
+	for i := len(cli.interceptors) - 1; i >= 0; i-- {
+		handler = cli.interceptors[i](handler)
+	}
+	return handler
+}
+
+// NewMetricsInterceptor calls register with the outcome of every request, mirroring NewMetricsMiddleware.
+// The status is "ERROR" for requests failing without a response.
+func NewMetricsInterceptor(register func(method, host, status string, elapsed time.Duration)) Interceptor {
+	return func(next RequestHandler) RequestHandler {
+		return func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := next(req)
+			status := "ERROR"
+			if err == nil {
+				status = strconv.Itoa(res.StatusCode)
+			}
+			register(req.Method, req.URL.Host, status, time.Since(start))
+
+			return res, err
+		}
+	}
+}
+
+// Retry settings.
+type Retry struct {
+	// MaxAttempts including the first one. Defaults to 3.
+	MaxAttempts int
+	// Backoff before the first retry, doubled on each retry, with jitter. Defaults to 100ms.
+	Backoff time.Duration
+	// MaxBackoff between attempts, including the ones set by Retry-After headers. Defaults to 5s.
+	MaxBackoff time.Duration
+	// Methods retried. Defaults to the idempotent methods GET, HEAD, OPTIONS, PUT and DELETE.
+	Methods []string
+	// RetryOn decides if a request is retried. Defaults to errors and the 429, 502, 503 and 504 status codes.
+	RetryOn func(res *http.Response, err error) bool
+}
+
+func (params Retry) sanitize() Retry {
+	if params.MaxAttempts <= 0 {
+		params.MaxAttempts = 3
+	}
+	if params.Backoff <= 0 {
+		params.Backoff = 100 * time.Millisecond
+	}
+	if params.MaxBackoff <= 0 {
+		params.MaxBackoff = 5 * time.Second
+	}
+	if params.Methods == nil {
+		params.Methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+	}
+	if params.RetryOn == nil {
+		params.RetryOn = retryable
+	}
+	return params
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// NewRetryInterceptor retries failed requests with exponential backoff, honoring Retry-After headers.
+// Requests are only retried if their body can be replayed, which is the case for all requests created by Requester.
+func NewRetryInterceptor(params Retry) Interceptor {
+	params = params.sanitize()
+	return func(next RequestHandler) RequestHandler {
+		return func(req *http.Request) (*http.Response, error) {
+			if !slices.Contains(params.Methods, req.Method) {
+				return next(req)
+			}
+			var ctx = req.Context()
+			var first = requestAttempt(ctx)
+			var backoff = params.Backoff
+			for attempt := 0; ; attempt++ {
+				areq := req
+				if attempt > 0 {
+					areq = req.Clone(withAttempt(ctx, first+attempt))
+					if req.GetBody != nil {
+						body, err := req.GetBody()
+						if err != nil {
+							return nil, err
+						}
+						areq.Body = body
+					}
+				}
+
+				res, err := next(areq)
+				if attempt+1 >= params.MaxAttempts || ctx.Err() != nil || !params.RetryOn(res, err) {
+					return res, err
+				}
+				if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+					return res, err
+				}
+
+				wait := backoff/2 + rand.N(backoff/2+1)
+				if res != nil {
+					if seconds, perr := strconv.Atoi(res.Header.Get(HeaderRetryAfter)); perr == nil {
+						wait = max(wait, time.Duration(seconds)*time.Second)
+					}
+					io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+					res.Body.Close()
+				}
+				timer := time.NewTimer(min(wait, params.MaxBackoff))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				case <-timer.C:
+				}
+				backoff = min(2*backoff, params.MaxBackoff)
+			}
+		}
+	}
+}
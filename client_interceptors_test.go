@@ -0,0 +1,159 @@
+package webservice
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Interceptors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(next RequestHandler) RequestHandler {
+			return func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				req.Header.Set("X-Trace", req.Header.Get("X-Trace")+name)
+				res, err := next(req)
+				if err == nil {
+					res.Header.Set("X-Seen-"+name, "true")
+				}
+				return res, err
+			}
+		}
+	}
+
+	cli := NewCustomClient(srv.URL, ClientOptions{Interceptors: []Interceptor{trace("a")}})
+	clone := cli.Clone()
+	clone.Use(trace("b"))
+
+	status, body, err := clone.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, []string{"a", "b"}, calls)
+
+	calls = nil
+	_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, calls, "interceptors added to clones are not shared")
+
+	t.Run("short circuit", func(t *testing.T) {
+		cached := NewCustomClient(srv.URL, ClientOptions{}).Use(func(next RequestHandler) RequestHandler {
+			return func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader("cached")),
+					Request:    req,
+				}, nil
+			}
+		})
+		status, body, err := cached.Request(context.Background(), http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, status)
+		assert.Equal(t, "cached", string(body))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		var got []string
+		metered := NewCustomClient(srv.URL, ClientOptions{}).Use(NewMetricsInterceptor(func(method, host, status string, elapsed time.Duration) {
+			got = append(got, method+" "+status)
+		}))
+		_, _, err := metered.Request(context.Background(), http.MethodPost, "/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"POST 200"}, got)
+	})
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := requests.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case "/throttled":
+			w.Header().Set(HeaderRetryAfter, "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var attempts []int
+	record := func(next RequestHandler) RequestHandler {
+		return func(req *http.Request) (*http.Response, error) {
+			attempts = append(attempts, requestAttempt(req.Context()))
+			return next(req)
+		}
+	}
+	cli := NewCustomClient(srv.URL, ClientOptions{}).Use(
+		NewRetryInterceptor(Retry{Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}),
+		record,
+	)
+
+	t.Run("retries with body", func(t *testing.T) {
+		requests.Store(0)
+		attempts = nil
+		status, body, err := cli.Request(context.Background(), http.MethodPut, "/flaky", []byte("payload"))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "payload", string(body))
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		requests.Store(0)
+		status, _, err := cli.Request(context.Background(), http.MethodGet, "/down", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, status)
+		assert.EqualValues(t, 3, requests.Load())
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		requests.Store(0)
+		status, _, err := cli.Request(context.Background(), http.MethodPost, "/down", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, status)
+		assert.EqualValues(t, 1, requests.Load())
+	})
+
+	t.Run("retry after capped", func(t *testing.T) {
+		requests.Store(0)
+		start := time.Now()
+		status, _, err := cli.Request(context.Background(), http.MethodGet, "/throttled", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, status)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		slow := NewCustomClient(srv.URL, ClientOptions{}).Use(NewRetryInterceptor(Retry{Backoff: time.Minute}))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, _, err := slow.Request(ctx, http.MethodGet, "/down", nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
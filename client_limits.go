@@ -0,0 +1,151 @@
+package webservice
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrThrottled is returned by requests rejected by the client's rate limit or concurrency bulkhead when failing fast.
+var ErrThrottled = errors.New("request throttled by client limits")
+
+// clientLimits applies the rate limit and the maximum number of concurrent requests of a Client.
+// Shared by all clones of a Client.
+type clientLimits struct {
+	rate       Rate
+	mu         sync.Mutex
+	bucket     *clientBucket
+	slots      chan struct{}
+	failFast   bool
+	onThrottle func(req *http.Request, wait time.Duration)
+}
+
+func newClientLimits(options ClientOptions) *clientLimits {
+	if options.RateLimit.Limit <= 0 && options.MaxConcurrentRequests <= 0 {
+		return nil
+	}
+	limits := &clientLimits{
+		failFast:   options.FailFast,
+		onThrottle: options.OnThrottle,
+	}
+	if options.RateLimit.Limit > 0 {
+		limits.rate = options.RateLimit.sanitize()
+		limits.bucket = &clientBucket{}
+	}
+	if options.MaxConcurrentRequests > 0 {
+		limits.slots = make(chan struct{}, options.MaxConcurrentRequests)
+	}
+	return limits
+}
+
+// clientBucket is a token bucket which also hands out future tokens, so requests waiting for the rate limit are
+// sent in order.
+type clientBucket struct {
+	tokenBucket
+}
+
+// reserve a token, possibly in the future, and return how long to wait for it.
+func (b *clientBucket) reserve(rate Rate, now time.Time) time.Duration {
+	b.refill(rate, now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(rate.interval()))
+}
+
+// acquire waits for the rate limit and a concurrency slot, or fails fast. The returned function must be called when
+// the request is done.
+func (limits *clientLimits) acquire(req *http.Request) (release func(), err error) {
+	var start = time.Now()
+	var waited bool
+	defer func() {
+		if waited && limits.onThrottle != nil {
+			limits.onThrottle(req, time.Since(start))
+		}
+	}()
+
+	if limits.bucket != nil {
+		limits.mu.Lock()
+		wait := limits.bucket.reserve(limits.rate, start)
+		if wait > 0 && limits.failFast {
+			limits.bucket.tokens++
+			wait = -1
+		}
+		limits.mu.Unlock()
+		if wait != 0 {
+			waited = true
+		}
+		if wait < 0 {
+			return nil, ErrThrottled
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				limits.mu.Lock()
+				limits.bucket.tokens++
+				limits.mu.Unlock()
+				return nil, req.Context().Err()
+			}
+		}
+	}
+
+	if limits.slots != nil {
+		select {
+		case limits.slots <- struct{}{}:
+		default:
+			waited = true
+			if limits.failFast {
+				return nil, ErrThrottled
+			}
+			select {
+			case limits.slots <- struct{}{}:
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+	}
+
+	return limits.release, nil
+}
+
+func (limits *clientLimits) release() {
+	if limits.slots != nil {
+		<-limits.slots
+	}
+}
+
+// intercept applies the limits to each request, keeping the concurrency slot until the response body is closed.
+func (limits *clientLimits) intercept(next RequestHandler) RequestHandler {
+	return func(req *http.Request) (*http.Response, error) {
+		release, err := limits.acquire(req)
+		if err != nil {
+			return nil, err
+		}
+		res, err := next(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		res.Body = &releaseOnClose{ReadCloser: res.Body, release: release}
+		return res, nil
+	}
+}
+
+// releaseOnClose releases the request limits once the response body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (body *releaseOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.release)
+	return err
+}
@@ -0,0 +1,103 @@
+package webservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Limits(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	t.Run("rate limit", func(t *testing.T) {
+		var mu sync.Mutex
+		var waits []time.Duration
+		cli := NewCustomClient(srv.URL, ClientOptions{
+			RateLimit: Rate{Limit: 20, Period: time.Second, Burst: 1},
+			OnThrottle: func(req *http.Request, wait time.Duration) {
+				mu.Lock()
+				waits = append(waits, wait)
+				mu.Unlock()
+			},
+		})
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			status, _, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+		assert.Len(t, waits, 2, "first request within the burst")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		_, _, err := cli.Request(ctx, http.MethodGet, "/", nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		clone := cli.Clone()
+		clone.limits.failFast = false
+		time.Sleep(50 * time.Millisecond)
+		_, _, err = clone.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.NoError(t, err, "token returned by the canceled request")
+	})
+
+	t.Run("rate limit fail fast", func(t *testing.T) {
+		cli := NewCustomClient(srv.URL, ClientOptions{RateLimit: Rate{Limit: 1, Period: time.Minute}, FailFast: true})
+		_, _, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.NoError(t, err)
+		_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.ErrorIs(t, err, ErrThrottled)
+	})
+
+	t.Run("bulkhead", func(t *testing.T) {
+		cli := NewCustomClient(srv.URL, ClientOptions{MaxConcurrentRequests: 1, FailFast: true})
+		done := make(chan error)
+		go func() {
+			_, _, err := cli.Request(context.Background(), http.MethodGet, "/block", nil)
+			done <- err
+		}()
+		require.Eventually(t, func() bool { return len(cli.limits.slots) == 1 }, time.Second, time.Millisecond)
+		_, _, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.ErrorIs(t, err, ErrThrottled)
+
+		blocking := NewCustomClient(srv.URL, ClientOptions{MaxConcurrentRequests: 1})
+		blocking.limits = cli.limits
+		blocking.limits.failFast = false
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, _, err = blocking.Request(ctx, http.MethodGet, "/", nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+		}()
+		_, _, err = blocking.Request(context.Background(), http.MethodGet, "/", nil)
+		assert.NoError(t, err, "waits for the slot")
+		assert.NoError(t, <-done)
+		assert.Len(t, cli.limits.slots, 0)
+	})
+
+	t.Run("stream released on close", func(t *testing.T) {
+		cli := NewCustomClient(srv.URL, ClientOptions{MaxConcurrentRequests: 1})
+		_, body, err := cli.NewStreamRequest().Do(context.Background(), http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		assert.Len(t, cli.limits.slots, 1)
+		body.Close()
+		body.Close()
+		assert.Len(t, cli.limits.slots, 0)
+	})
+}
@@ -0,0 +1,176 @@
+package webservice
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+// DefaultRedactedParams are the query parameters whose values are redacted from client logs by default.
+var DefaultRedactedParams = []string{"access_token", "api_key", "apikey", "key", "password", "secret", "signature", "token"}
+
+// ClientLogger settings.
+type ClientLogger struct {
+	// Logger used for writing a record for each request sent by the client.
+	// If no logger is passed then no logs will be written.
+	Logger *slog.Logger
+	// Level of the requests logged, as for the server access logs. Requests failing without a response are always
+	// logged.
+	Level AccessLogLevel
+	// SampleRate is the fraction of requests logged, between 0 and 1. Failed requests and 5XX status codes are always
+	// logged. Defaults to 1.
+	SampleRate float64
+	// RedactedParams are the query parameters whose values are replaced in the logged URLs.
+	// Defaults to DefaultRedactedParams. Passwords in URLs are always redacted.
+	RedactedParams []string
+}
+
+// clientLogger mirrors accessLogger for requests sent by the client. Records are written once the response body is
+// closed, or when the request fails.
+type clientLogger struct {
+	log    logger.SLogger
+	level  AccessLogLevel
+	sample float64
+	redact map[string]bool
+}
+
+func newClientLogger(params ClientLogger) *clientLogger {
+	if params.Logger == nil {
+		return nil
+	}
+	if params.SampleRate <= 0 || params.SampleRate > 1 {
+		params.SampleRate = 1
+	}
+	if params.RedactedParams == nil {
+		params.RedactedParams = DefaultRedactedParams
+	}
+	redact := make(map[string]bool, len(params.RedactedParams))
+	for _, name := range params.RedactedParams {
+		redact[strings.ToLower(name)] = true
+	}
+	return &clientLogger{
+		log:    logger.NewSLogWrapper(params.Logger),
+		level:  params.Level,
+		sample: params.SampleRate,
+		redact: redact,
+	}
+}
+
+// discard mirrors AccessLogDiscarder, sampling the requests which would be logged.
+func (l *clientLogger) discard(status int) bool {
+	switch {
+	case status == 0 || status >= 500:
+		return false
+	case l.level == AccessLogLevelError:
+		return true
+	case l.level == AccessLogLevelWarn && (status == http.StatusNotFound || status < 400):
+		return true
+	case l.level == AccessLogLevelInfo && (status == http.StatusNotFound || status >= 300 && status < 400):
+		return true
+	}
+	return l.sample < 1 && rand.Float64() >= l.sample
+}
+
+// redactURL removes passwords and the values of sensitive query parameters.
+func (l *clientLogger) redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+	query := u.Query()
+	for name := range query {
+		if l.redact[strings.ToLower(name)] {
+			for i := range query[name] {
+				query[name][i] = "xxxxx"
+			}
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.Redacted()
+}
+
+// intercept logs each request once it fails or its response body is closed.
+func (l *clientLogger) intercept(next RequestHandler) RequestHandler {
+	return func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		res, err := next(req)
+		if err != nil {
+			l.write(req, start, 0, 0, err)
+			return nil, err
+		}
+		body := &countingBody{ReadCloser: res.Body}
+		body.onClose = func() { l.write(req, start, res.StatusCode, body.n, nil) }
+		res.Body = body
+		return res, nil
+	}
+}
+
+func (l *clientLogger) write(req *http.Request, start time.Time, status int, bytesIn int64, err error) {
+	if l.discard(status) {
+		return
+	}
+	var uri = l.redactURL(req.URL)
+	var log = l.log.With(
+		slog.String("method", req.Method),
+		slog.String("url", uri),
+		slog.String("host", req.URL.Host),
+		slog.Int("status", status),
+		slog.Int64("bytes_out", max(req.ContentLength, 0)),
+		slog.Int64("bytes_in", bytesIn),
+		slog.Int("attempt", requestAttempt(req.Context())),
+		slog.Duration("latency_ns", time.Since(start)),
+	)
+
+	if err != nil {
+		log.Errorf("%s %s: %+v", req.Method, uri, err)
+		return
+	}
+	if status >= 500 && status < 600 {
+		log.Errorf("%s %s", req.Method, uri)
+		return
+	}
+	log.Infof("%s %s", req.Method, uri)
+}
+
+// countingBody counts the bytes read from a response body and calls onClose once closed.
+type countingBody struct {
+	io.ReadCloser
+	n       int64
+	once    sync.Once
+	onClose func()
+}
+
+func (body *countingBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.n += int64(n)
+	return n, err
+}
+
+func (body *countingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.onClose)
+	return err
+}
+
+type contextKeyAttempt struct{}
+
+// withAttempt sets the attempt number of a request, for requests sent more than once.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, contextKeyAttempt{}, attempt)
+}
+
+// requestAttempt returns the attempt number of a request, starting at 1.
+func requestAttempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(contextKeyAttempt{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
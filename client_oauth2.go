@@ -0,0 +1,310 @@
+package webservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// Token obtained from a TokenSource.
+type Token struct {
+	AccessToken string
+	// TokenType defaults to Bearer.
+	TokenType string
+	// Expiry of the token, zero if it does not expire.
+	Expiry time.Time
+}
+
+// TokenSource provides the tokens used for authenticating requests, see NewTokenAuthInterceptor.
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// tokenInvalidator is implemented by token sources able to drop tokens rejected by the server.
+type tokenInvalidator interface {
+	Invalidate(token Token)
+}
+
+// JWTAssertion settings for the JWT bearer assertion grant (RFC 7523).
+type JWTAssertion struct {
+	// Issuer and Subject of the assertion, usually the client ID.
+	Issuer  string
+	Subject string
+	// Audience of the assertion. Defaults to the token URL.
+	Audience string
+	// Key signing the assertion: *rsa.PrivateKey (RS256), P-256 *ecdsa.PrivateKey (ES256), ed25519.PrivateKey (EdDSA)
+	// or a []byte secret (HS256). KeyID is set as the "kid" header, if any.
+	Key   any
+	KeyID string
+	// Lifetime of the assertion. Defaults to 5 minutes.
+	Lifetime time.Duration
+	// Claims added to the assertion.
+	Claims map[string]any
+}
+
+// OAuth2 settings for obtaining tokens from a token endpoint using the client credentials grant, or the JWT bearer
+// assertion grant if Assertion is set.
+type OAuth2 struct {
+	// TokenURL of the authorization server.
+	TokenURL string
+	// ClientID and ClientSecret sent using basic authentication. Optional for the JWT bearer assertion grant.
+	ClientID     string
+	ClientSecret string
+	// Scopes requested.
+	Scopes []string
+	// Params are additional parameters of the token requests, e.g. an audience.
+	Params url.Values
+	// Assertion enables the JWT bearer assertion grant.
+	Assertion *JWTAssertion
+	// ExpiryDelta is how long before their expiry tokens stop being used. Defaults to 10 seconds.
+	ExpiryDelta time.Duration
+	// RefreshBefore is how long before their expiry tokens are refreshed in the background. Defaults to 1 minute.
+	RefreshBefore time.Duration
+	// HTTPClient used for the token requests. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// OAuth2TokenSource is a TokenSource caching the tokens obtained from an OAuth2 token endpoint. Concurrent token
+// requests are merged into a single one and tokens close to their expiry are refreshed in the background.
+type OAuth2TokenSource struct {
+	params OAuth2
+	now    func() time.Time
+	mu     sync.Mutex
+	token  Token
+	call   *tokenCall
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token Token
+	err   error
+}
+
+// NewOAuth2TokenSource creates a token source, tokens are only requested when first needed.
+func NewOAuth2TokenSource(params OAuth2) *OAuth2TokenSource {
+	if params.ExpiryDelta <= 0 {
+		params.ExpiryDelta = 10 * time.Second
+	}
+	if params.RefreshBefore <= 0 {
+		params.RefreshBefore = time.Minute
+	}
+	if params.HTTPClient == nil {
+		params.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OAuth2TokenSource{params: params, now: time.Now}
+}
+
+// Token returns the cached token, requesting a new one if there is none or it is about to expire.
+func (ts *OAuth2TokenSource) Token(ctx context.Context) (Token, error) {
+	ts.mu.Lock()
+	token, now := ts.token, ts.now()
+	if token.AccessToken != "" && (token.Expiry.IsZero() || now.Before(token.Expiry.Add(-ts.params.ExpiryDelta))) {
+		if !token.Expiry.IsZero() && !now.Before(token.Expiry.Add(-ts.params.RefreshBefore)) {
+			ts.refresh(ctx)
+		}
+		ts.mu.Unlock()
+		return token, nil
+	}
+	call := ts.refresh(ctx)
+	ts.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return Token{}, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token if it is the one passed, e.g. after being rejected by the server.
+func (ts *OAuth2TokenSource) Invalidate(token Token) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token.AccessToken == token.AccessToken {
+		ts.token = Token{}
+	}
+}
+
+// refresh starts a token request unless one is already running. Must hold the lock.
+func (ts *OAuth2TokenSource) refresh(ctx context.Context) *tokenCall {
+	if ts.call != nil {
+		return ts.call
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	ts.call = call
+	// the request is shared with other callers so it must not be canceled with the caller's context
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		call.token, call.err = ts.request(ctx)
+		ts.mu.Lock()
+		if call.err == nil {
+			ts.token = call.token
+		}
+		ts.call = nil
+		ts.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// request a token from the token endpoint.
+func (ts *OAuth2TokenSource) request(ctx context.Context) (Token, error) {
+	var params = ts.params
+	var form = url.Values{}
+	for k, v := range params.Params {
+		form[k] = v
+	}
+	form.Set("grant_type", grantTypeClientCredentials)
+	if len(params.Scopes) > 0 {
+		form.Set("scope", strings.Join(params.Scopes, " "))
+	}
+	if params.Assertion != nil {
+		assertion, err := ts.assertion()
+		if err != nil {
+			return Token{}, err
+		}
+		form.Set("grant_type", grantTypeJWTBearer)
+		form.Set("assertion", assertion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, params.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to create token request; %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if params.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(params.ClientID), url.QueryEscape(params.ClientSecret))
+	}
+
+	start := ts.now()
+	res, err := params.HTTPClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("error running token request; %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to read token response; %w", err)
+	}
+
+	var payload tokenResponse
+	jerr := json.Unmarshal(body, &payload)
+	if res.StatusCode != http.StatusOK {
+		if jerr == nil && payload.Error != "" {
+			return Token{}, fmt.Errorf("token request failed with status %d: %s %s", res.StatusCode, payload.Error, payload.ErrorDescription)
+		}
+		return Token{}, fmt.Errorf("token request failed with status %d", res.StatusCode)
+	}
+	if jerr != nil {
+		return Token{}, fmt.Errorf("invalid token response; %w", jerr)
+	}
+	if payload.AccessToken == "" {
+		return Token{}, errors.New("invalid token response; missing access token")
+	}
+
+	token := Token{AccessToken: payload.AccessToken, TokenType: payload.TokenType}
+	if payload.ExpiresIn > 0 {
+		token.Expiry = start.Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// assertion signs a new JWT bearer assertion.
+func (ts *OAuth2TokenSource) assertion() (string, error) {
+	var params = *ts.params.Assertion
+	if params.Audience == "" {
+		params.Audience = ts.params.TokenURL
+	}
+	if params.Lifetime <= 0 {
+		params.Lifetime = 5 * time.Minute
+	}
+	var jti [16]byte
+	rand.Read(jti[:])
+
+	now := ts.now()
+	claims := make(map[string]any, len(params.Claims)+6)
+	for k, v := range params.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = params.Issuer
+	claims["sub"] = params.Subject
+	claims["aud"] = params.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(params.Lifetime).Unix()
+	claims["jti"] = hex.EncodeToString(jti[:])
+
+	assertion, err := signJWT(params.Key, params.KeyID, claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to create JWT assertion; %w", err)
+	}
+	return assertion, nil
+}
+
+// NewTokenAuthInterceptor sets the Authorization header of requests with tokens from the source. Requests rejected
+// with a 401 status code are retried once with a new token, if the source supports invalidating tokens, as
+// OAuth2TokenSource does, and the request body can be replayed.
+func NewTokenAuthInterceptor(source TokenSource) Interceptor {
+	return func(next RequestHandler) RequestHandler {
+		send := func(req *http.Request) (*http.Response, Token, error) {
+			token, err := source.Token(req.Context())
+			if err != nil {
+				return nil, token, fmt.Errorf("failed to get token; %w", err)
+			}
+			kind := token.TokenType
+			if kind == "" || strings.EqualFold(kind, "bearer") {
+				kind = "Bearer"
+			}
+			req.Header.Set("Authorization", kind+" "+token.AccessToken)
+			res, err := next(req)
+			return res, token, err
+		}
+
+		return func(req *http.Request) (*http.Response, error) {
+			res, token, err := send(req.Clone(req.Context()))
+			if err != nil || res.StatusCode != http.StatusUnauthorized {
+				return res, err
+			}
+			invalidator, ok := source.(tokenInvalidator)
+			if !ok || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+				return res, err
+			}
+
+			retry := req.Clone(withAttempt(req.Context(), requestAttempt(req.Context())+1))
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return res, nil
+				}
+				retry.Body = body
+			}
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+			invalidator.Invalidate(token)
+			res, _, err = send(retry)
+			return res, err
+		}
+	}
+}
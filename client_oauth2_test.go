@@ -0,0 +1,197 @@
+package webservice
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenServer struct {
+	*httptest.Server
+	issued    atomic.Int32
+	expiresIn atomic.Int64
+	delay     time.Duration
+	lastForm  atomic.Value
+}
+
+func newTokenServer(t *testing.T, delay time.Duration) *tokenServer {
+	ts := &tokenServer{delay: delay}
+	ts.expiresIn.Store(3600)
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		ts.lastForm.Store(r.PostForm)
+		if r.PostForm.Get("grant_type") == grantTypeClientCredentials {
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "client" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+				return
+			}
+		}
+		time.Sleep(ts.delay)
+		n := ts.issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("t%d", n),
+			"token_type":   "bearer",
+			"expires_in":   ts.expiresIn.Load(),
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestOAuth2TokenSource(t *testing.T) {
+	t.Run("client credentials cached", func(t *testing.T) {
+		srv := newTokenServer(t, 0)
+		source := NewOAuth2TokenSource(OAuth2{
+			TokenURL:     srv.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+		})
+		for i := 0; i < 3; i++ {
+			token, err := source.Token(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, "t1", token.AccessToken)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, 5*time.Second)
+		}
+		assert.EqualValues(t, 1, srv.issued.Load())
+		assert.Equal(t, "read write", srv.lastForm.Load().(url.Values)["scope"][0])
+	})
+
+	t.Run("singleflight", func(t *testing.T) {
+		srv := newTokenServer(t, 50*time.Millisecond)
+		source := NewOAuth2TokenSource(OAuth2{TokenURL: srv.URL, ClientID: "client", ClientSecret: "secret"})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := source.Token(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, "t1", token.AccessToken)
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, srv.issued.Load())
+	})
+
+	t.Run("expiry and background refresh", func(t *testing.T) {
+		srv := newTokenServer(t, 0)
+		srv.expiresIn.Store(120)
+		source := NewOAuth2TokenSource(OAuth2{TokenURL: srv.URL, ClientID: "client", ClientSecret: "secret"})
+		token, err := source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "t1", token.AccessToken)
+
+		// within the refresh window the cached token is returned while a new one is requested
+		source.now = func() time.Time { return time.Now().Add(70 * time.Second) }
+		token, err = source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "t1", token.AccessToken)
+		require.Eventually(t, func() bool {
+			token, _ := source.Token(context.Background())
+			return token.AccessToken == "t2"
+		}, time.Second, 10*time.Millisecond)
+
+		// expired tokens are never returned
+		source.now = func() time.Time { return time.Now().Add(time.Hour) }
+		token, err = source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "t3", token.AccessToken)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		srv := newTokenServer(t, 0)
+		source := NewOAuth2TokenSource(OAuth2{TokenURL: srv.URL, ClientID: "client", ClientSecret: "wrong"})
+		_, err := source.Token(context.Background())
+		assert.ErrorContains(t, err, "status 401: invalid_client bad credentials")
+	})
+
+	t.Run("jwt bearer assertion", func(t *testing.T) {
+		srv := newTokenServer(t, 0)
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		source := NewOAuth2TokenSource(OAuth2{
+			TokenURL:  srv.URL,
+			Assertion: &JWTAssertion{Issuer: "client", Subject: "client", Key: key, KeyID: "k1"},
+		})
+		token, err := source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "t1", token.AccessToken)
+
+		form := srv.lastForm.Load().(url.Values)
+		assert.Equal(t, grantTypeJWTBearer, form["grant_type"][0])
+		parts := strings.Split(form["assertion"][0], ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		assert.True(t, ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), signature))
+
+		var header, claims map[string]any
+		decode := func(part string, v any) {
+			data, err := base64.RawURLEncoding.DecodeString(part)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, v))
+		}
+		decode(parts[0], &header)
+		decode(parts[1], &claims)
+		assert.Equal(t, map[string]any{"alg": "EdDSA", "typ": "JWT", "kid": "k1"}, header)
+		assert.Equal(t, "client", claims["iss"])
+		assert.Equal(t, srv.URL, claims["aud"])
+		assert.NotEmpty(t, claims["jti"])
+	})
+}
+
+func TestTokenAuthInterceptor(t *testing.T) {
+	tokens := newTokenServer(t, 0)
+	var accepted atomic.Value
+	accepted.Store("Bearer t1")
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != accepted.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer api.Close()
+
+	source := NewOAuth2TokenSource(OAuth2{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "secret"})
+	cli := NewCustomClient(api.URL, ClientOptions{}).Use(NewTokenAuthInterceptor(source))
+
+	status, body, err := cli.Request(context.Background(), http.MethodPost, "/", []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello", string(body))
+
+	// the token is revoked, the request is retried once with a new token
+	accepted.Store("Bearer t2")
+	status, body, err = cli.Request(context.Background(), http.MethodPost, "/", []byte("again"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "again", string(body))
+	assert.EqualValues(t, 2, tokens.issued.Load())
+
+	// only retried once
+	accepted.Store("none")
+	status, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.EqualValues(t, 3, tokens.issued.Load())
+}
@@ -0,0 +1,148 @@
+package webservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DNSLookup performs the DNS lookups of the endpoint resolvers. Implemented by net.Resolver, replace it in tests.
+type DNSLookup interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// SRVResolver is an EndpointResolver using DNS SRV records, e.g. "_http._tcp.service.namespace.svc.cluster.local".
+// Only the targets with the lowest priority are used, weighted by their SRV weight.
+// Use it with BalancingOptions.ResolveInterval for following changes to the records.
+type SRVResolver struct {
+	// Service and Proto of the SRV record, e.g. "http" and "tcp". Name is looked up directly if both are empty.
+	Service string
+	Proto   string
+	Name    string
+	// Scheme of the endpoints. Defaults to http.
+	Scheme string
+	// Path of the endpoints, e.g. "/api".
+	Path string
+	// Lookup defaults to net.DefaultResolver.
+	Lookup DNSLookup
+	// Hook is called with the result of each lookup.
+	Hook func(event DialerHookEvent)
+}
+
+// ResolveEndpoints looks up the SRV records.
+func (r SRVResolver) ResolveEndpoints(ctx context.Context) ([]Endpoint, error) {
+	var lookup = r.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+	var scheme = r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	_, records, err := lookup.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		r.report(DialerHookEvent{Msg: "dns srv lookup failed", Err: err, Host: r.Name})
+		return nil, fmt.Errorf("failed to lookup SRV records of %s; %w", r.Name, err)
+	}
+	r.report(DialerHookEvent{Msg: "dns srv lookup", Host: r.Name})
+	if len(records) == 0 {
+		return nil, errNoEndpoints
+	}
+
+	var priority = slices.MinFunc(records, func(a, b *net.SRV) int { return int(a.Priority) - int(b.Priority) }).Priority
+	var endpoints []Endpoint
+	for _, srv := range records {
+		if srv.Priority != priority {
+			continue
+		}
+		host := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		endpoints = append(endpoints, Endpoint{
+			URL:    (&url.URL{Scheme: scheme, Host: host, Path: r.Path}).String(),
+			Weight: max(int(srv.Weight), 1),
+		})
+	}
+
+	return endpoints, nil
+}
+
+func (r SRVResolver) report(event DialerHookEvent) {
+	if r.Hook != nil {
+		r.Hook(event)
+	}
+}
+
+// DNSResolver is an EndpointResolver with one endpoint per address of the host of a base URL, from its A and AAAA
+// records. Requests connect to the addresses while keeping the URL's host for the Host header and TLS verification,
+// see Endpoint.Address. Use it with BalancingOptions.ResolveInterval for following changes to the records, e.g. for
+// spreading connections across the pods of headless services.
+type DNSResolver struct {
+	// URL to resolve, e.g. "http://service.namespace:8080/api".
+	URL string
+	// Lookup defaults to net.DefaultResolver.
+	Lookup DNSLookup
+	// Hook is called with the result of each lookup.
+	Hook func(event DialerHookEvent)
+}
+
+// ResolveEndpoints looks up the addresses of the URL's host.
+func (r DNSResolver) ResolveEndpoints(ctx context.Context) ([]Endpoint, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint URL; %w", err)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("endpoint URL has no host")
+	}
+	var lookup = r.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+
+	ips, err := lookupIP(ctx, lookup, u.Hostname(), DialerHookEvent{Host: r.URL, Address: u.Host}, r.Hook)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errNoEndpoints
+	}
+	var port = u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	endpoints := make([]Endpoint, len(ips))
+	for i, ip := range ips {
+		endpoints[i] = Endpoint{URL: r.URL, Address: net.JoinHostPort(ip.String(), port)}
+	}
+
+	return endpoints, nil
+}
+
+// lookupIP resolves the addresses of a hostname, reporting the result to the hook with the event's host and address.
+func lookupIP(ctx context.Context, lookup DNSLookup, hostname string, event DialerHookEvent, hook func(event DialerHookEvent)) ([]net.IP, error) {
+	addrs, err := lookup.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		if hook != nil {
+			event.Msg, event.Err = "dns lookup failed", err
+			hook(event)
+		}
+		return nil, fmt.Errorf("failed to lookup %s; %w", hostname, err)
+	}
+	event.Msg, event.Lookups = "dns lookup", make([]net.IP, len(addrs))
+	for i := range addrs {
+		event.Lookups[i] = addrs[i].IP
+	}
+	if hook != nil {
+		hook(event)
+	}
+	return event.Lookups, nil
+}
@@ -0,0 +1,234 @@
+package webservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDNS struct {
+	mu    sync.Mutex
+	srv   []*net.SRV
+	addrs []net.IPAddr
+	err   error
+	names []string
+}
+
+func (dns *fakeDNS) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	dns.names = append(dns.names, "_"+service+"._"+proto+"."+name)
+	return name, dns.srv, dns.err
+}
+
+func (dns *fakeDNS) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	dns.names = append(dns.names, host)
+	return dns.addrs, dns.err
+}
+
+func (dns *fakeDNS) setAddrs(ips ...string) {
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	dns.addrs = nil
+	for _, ip := range ips {
+		dns.addrs = append(dns.addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+}
+
+func TestSRVResolver(t *testing.T) {
+	dns := &fakeDNS{srv: []*net.SRV{
+		{Target: "pod-1.svc.local.", Port: 8080, Priority: 10, Weight: 5},
+		{Target: "pod-2.svc.local.", Port: 8081, Priority: 10},
+		{Target: "backup.svc.local.", Port: 8080, Priority: 20, Weight: 100},
+	}}
+	var events []DialerHookEvent
+	resolver := SRVResolver{Service: "http", Proto: "tcp", Name: "svc.local", Path: "/api", Lookup: dns, Hook: func(event DialerHookEvent) {
+		events = append(events, event)
+	}}
+
+	endpoints, err := resolver.ResolveEndpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{
+		{URL: "http://pod-1.svc.local:8080/api", Weight: 5},
+		{URL: "http://pod-2.svc.local:8081/api", Weight: 1},
+	}, endpoints, "only the lowest priority")
+	assert.Equal(t, []string{"_http._tcp.svc.local"}, dns.names)
+	assert.Equal(t, []DialerHookEvent{{Msg: "dns srv lookup", Host: "svc.local"}}, events)
+
+	dns.err = errors.New("no such host")
+	_, err = resolver.ResolveEndpoints(context.Background())
+	assert.ErrorIs(t, err, dns.err)
+	assert.Equal(t, "dns srv lookup failed", events[1].Msg)
+
+	dns.err, dns.srv = nil, nil
+	_, err = resolver.ResolveEndpoints(context.Background())
+	assert.ErrorIs(t, err, errNoEndpoints)
+}
+
+func TestDNSResolver(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.setAddrs("10.0.0.1", "fd00::1")
+	var events []DialerHookEvent
+	resolver := DNSResolver{URL: "https://svc.local:8443/api", Lookup: dns, Hook: func(event DialerHookEvent) {
+		events = append(events, event)
+	}}
+
+	endpoints, err := resolver.ResolveEndpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{
+		{URL: "https://svc.local:8443/api", Address: "10.0.0.1:8443"},
+		{URL: "https://svc.local:8443/api", Address: "[fd00::1]:8443"},
+	}, endpoints)
+	assert.Equal(t, []DialerHookEvent{{
+		Msg:     "dns lookup",
+		Host:    "https://svc.local:8443/api",
+		Address: "svc.local:8443",
+		Lookups: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")},
+	}}, events)
+
+	endpoints, err = DNSResolver{URL: "http://svc.local", Lookup: dns}.ResolveEndpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{{URL: "http://svc.local", Address: "10.0.0.1:80"}, {URL: "http://svc.local", Address: "[fd00::1]:80"}}, endpoints)
+
+	_, err = DNSResolver{URL: "/path", Lookup: dns}.ResolveEndpoints(context.Background())
+	assert.Error(t, err)
+}
+
+func TestBalancedClient_DNSResolver(t *testing.T) {
+	var mu sync.Mutex
+	var hosts = make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts[r.Host]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	dns := &fakeDNS{}
+	dns.setAddrs("127.0.0.1")
+	cli, err := NewBalancedClient(BalancingOptions{
+		Resolver:        DNSResolver{URL: "http://service.local:" + u.Port(), Lookup: dns},
+		ResolveInterval: 5 * time.Millisecond,
+	}, ClientOptions{Conn: NewConn(DefaultConnOptions)})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	// the test server only listens on 127.0.0.1 so requests to the new address fail over
+	dns.setAddrs("127.0.0.1", "127.0.0.2")
+	require.Eventually(t, func() bool { return len(cli.Endpoints()) == 2 }, time.Second, time.Millisecond)
+	for i := 0; i < 4; i++ {
+		_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "127.0.0.2:"+u.Port(), cli.Endpoints()[1].Address)
+	assert.Positive(t, cli.Endpoints()[1].Failures, "new address was picked")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"service.local:" + u.Port(): 5}, hosts, "host kept")
+}
+
+func TestBalancedClient_DNSResolverTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.TLS.ServerName))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// the test server certificate is valid for example.com
+	dns := &fakeDNS{}
+	dns.setAddrs("127.0.0.1")
+	cli, err := NewBalancedClient(BalancingOptions{
+		Resolver: DNSResolver{URL: "https://example.com:" + u.Port(), Lookup: dns},
+	}, ClientOptions{Conn: NewConn(DefaultConnOptions.WithTLSConfig(srv.Client().Transport.(*http.Transport).TLSClientConfig))})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	status, body, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "example.com:"+u.Port()+" example.com", string(body))
+}
+
+func TestBalancedClient_EndpointAddresses(t *testing.T) {
+	var mu sync.Mutex
+	var backends = make(map[string]int)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			backends[name]++
+			mu.Unlock()
+		})
+	}
+	first := httptest.NewServer(handler("first"))
+	defer first.Close()
+	u, err := url.Parse(first.URL)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.2:"+u.Port())
+	if err != nil {
+		t.Skipf("127.0.0.2 not available: %v", err)
+	}
+	second := httptest.NewUnstartedServer(handler("second"))
+	second.Listener.Close()
+	second.Listener = l
+	second.Start()
+	defer second.Close()
+
+	t.Run("pooled per address", func(t *testing.T) {
+		dns := &fakeDNS{}
+		dns.setAddrs("127.0.0.1", "127.0.0.2")
+		conn := NewConn(DefaultConnOptions.WithInstrumentation(nil))
+		cli, err := NewBalancedClient(BalancingOptions{
+			Resolver: DNSResolver{URL: "http://service.local:" + u.Port(), Lookup: dns},
+		}, ClientOptions{Conn: conn})
+		require.NoError(t, err)
+		defer cli.Close()
+
+		for i := 0; i < 6; i++ {
+			_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+			require.NoError(t, err)
+		}
+		mu.Lock()
+		assert.Equal(t, map[string]int{"first": 3, "second": 3}, backends)
+		mu.Unlock()
+		stats := cli.ConnStats()["service.local:"+u.Port()]
+		assert.Equal(t, int64(2), stats.Dials, "one connection per address, kept alive")
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("proxied " + r.URL.String()))
+		}))
+		defer proxy.Close()
+		proxyURL, err := url.Parse(proxy.URL)
+		require.NoError(t, err)
+
+		conn := NewConn(DefaultConnOptions)
+		conn.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+		cli, err := NewBalancedClient(BalancingOptions{
+			Resolver: &testResolver{endpoints: []Endpoint{{URL: "http://service.local", Address: "127.0.0.2:" + u.Port()}}},
+		}, ClientOptions{Conn: conn})
+		require.NoError(t, err)
+		defer cli.Close()
+
+		_, body, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "proxied http://service.local/", string(body), "the proxy is dialed, not the address")
+	})
+}
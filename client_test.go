@@ -182,3 +182,28 @@ func TestClient_NewRequest(t *testing.T) {
 		assert.Equal(t, 5, len(h3.Header))
 	})
 }
+
+func TestClient_PropagateDeadline(t *testing.T) {
+	cli := NewCustomClient("http://127.0.0.1:8080", ClientOptions{PropagateDeadline: true})
+	req := cli.NewRequest()
+
+	r, err := req.Prepare(context.Background(), "GET", "/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, r.Header.Get(HeaderRequestTimeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err = req.Prepare(ctx, "GET", "/", nil)
+	assert.NoError(t, err)
+	timeout, err := parseTimeout(r.Header.Get(HeaderRequestTimeout))
+	assert.NoError(t, err)
+	assert.InDelta(t, 5*time.Second, timeout, float64(time.Second))
+
+	r, err = req.Prepare(context.Background(), "GET", "/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, r.Header.Get(HeaderRequestTimeout), "headers are not shared between requests")
+
+	r, err = NewClient("http://127.0.0.1:8080").NewRequest().Prepare(ctx, "GET", "/", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, r.Header.Get(HeaderRequestTimeout), "disabled by default")
+}
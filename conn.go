@@ -1,12 +1,16 @@
 package webservice
 
 import (
+	"context"
 	"crypto/tls"
+	"math"
 	"net"
 	"net/http"
-	"net/url"
-	"syscall"
+	"net/http/httptrace"
+	"sync"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // DefaultConnOptions are the typicall connection options for the usual HTTP Client.
@@ -29,8 +33,17 @@ type ConnOptions struct {
 	keepAlive           time.Duration
 	connTimeout         time.Duration
 	requestTimeout      time.Duration
-	dialerControl       *dialerControl
+	dialerHook          func(dial dialFunc) dialFunc
 	tls                 *tls.Config
+	http2               bool
+	h2c                 bool
+	responseTimeout     time.Duration
+	readBufferSize      int
+	writeBufferSize     int
+	maxHeaderBytes      int64
+	noCompression       bool
+	instrumented        bool
+	traceHook           func(trace ConnTrace)
 }
 
 // WithMaxIdleConns sets the maximum idle connections left alive.
@@ -68,9 +81,10 @@ func (options ConnOptions) WithMaxConnsPerHost(value int) ConnOptions {
 	return options
 }
 
-// WithDialerHook allows providing a function which is called each time a dialer is executed.
+// WithDialerHook allows providing a function which is called each time a connection is dialed, with the outcome of
+// the dial. The host is only used for identifying the events.
 func (options ConnOptions) WithDialerHook(host string, handler func(event DialerHookEvent)) ConnOptions {
-	options.dialerControl = &dialerControl{hook: newDialerHook(host, handler)}
+	options.dialerHook = newDialerHook(host, handler)
 
 	return options
 }
@@ -87,28 +101,182 @@ func (options ConnOptions) WithTLSConfig(config *tls.Config) ConnOptions {
 	return options
 }
 
+// WithHTTP2 attempts HTTP/2 on TLS connections.
+// Go only enables HTTP/2 by default when no custom dialer or TLS config is used, which is never the case in NewConn.
+func (options ConnOptions) WithHTTP2(enabled bool) ConnOptions {
+	options.http2 = enabled
+
+	return options
+}
+
+// WithH2C uses HTTP/2 over cleartext connections with prior knowledge, i.e. without upgrading from HTTP/1.
+// Only use this for http:// services which are known to support h2c, typically internal services behind a mesh.
+// TLS connections are not supported by connections created with this option.
+// The buffer sizes and response header timeout options do not apply to h2c connections.
+func (options ConnOptions) WithH2C(enabled bool) ConnOptions {
+	options.h2c = enabled
+
+	return options
+}
+
+// WithResponseHeaderTimeout sets the maximum time to wait for the response headers after writing the request.
+func (options ConnOptions) WithResponseHeaderTimeout(value time.Duration) ConnOptions {
+	options.responseTimeout = value
+
+	return options
+}
+
+// WithBufferSizes sets the size of the read and write buffers of each connection. Uses 4KB if 0.
+func (options ConnOptions) WithBufferSizes(read, write int) ConnOptions {
+	options.readBufferSize = read
+	options.writeBufferSize = write
+
+	return options
+}
+
+// WithMaxResponseHeaderBytes limits the size of the response headers. Uses the transport default if 0 or negative.
+func (options ConnOptions) WithMaxResponseHeaderBytes(value int64) ConnOptions {
+	options.maxHeaderBytes = value
+
+	return options
+}
+
+// WithCompressionDisabled stops requesting gzip compressed responses.
+// By default Go requests compressed responses and transparently decompresses them.
+func (options ConnOptions) WithCompressionDisabled(value bool) ConnOptions {
+	options.noCompression = value
+
+	return options
+}
+
+// WithInstrumentation traces requests with httptrace and keeps the connection stats of each host, see
+// Client.ConnStats. The hook is called with the trace of each request once the response headers are received, or the
+// request fails, and can be nil for only keeping the stats.
+func (options ConnOptions) WithInstrumentation(hook func(trace ConnTrace)) ConnOptions {
+	options.instrumented = true
+	options.traceHook = hook
+
+	return options
+}
+
 // DialerHookEvent data.
 type DialerHookEvent struct {
-	Msg     string
-	Err     error
-	Host    string
+	// Msg is "dial" or "dial failed" for dialer hooks.
+	Msg string
+	Err error
+	// Host passed to ConnOptions.WithDialerHook.
+	Host string
+	// Address dialed, e.g. "example.com:443". For balanced clients this is the Endpoint.Address, if set, and not the
+	// host of the endpoint URL.
 	Address string
+	// Lookups are the addresses the host resolved to, if it was resolved by the dial.
 	Lookups []net.IP
+	// Network dialed, e.g. "tcp".
+	Network string
+	// RemoteAddr is the address connected to, if successful.
+	RemoteAddr string
+	// Duration of the dial, including the DNS lookup.
+	Duration time.Duration
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// newDialerHook wraps dial functions reporting each dial to the handler. The resolved addresses are taken from the
+// lookup done by the dial itself, through httptrace.
+func newDialerHook(host string, handler func(event DialerHookEvent)) func(dial dialFunc) dialFunc {
+	return func(dial dialFunc) dialFunc {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			var mu sync.Mutex
+			var lookups []net.IP
+			ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				DNSDone: func(info httptrace.DNSDoneInfo) {
+					mu.Lock()
+					defer mu.Unlock()
+					for _, addr := range info.Addrs {
+						lookups = append(lookups, addr.IP)
+					}
+				},
+			})
+
+			start := time.Now()
+			conn, err := dial(ctx, network, address)
+			event := DialerHookEvent{Msg: "dial", Err: err, Host: host, Address: address, Network: network, Duration: time.Since(start)}
+			mu.Lock()
+			event.Lookups = lookups
+			mu.Unlock()
+			if err != nil {
+				event.Msg = "dial failed"
+			} else {
+				event.RemoteAddr = conn.RemoteAddr().String()
+			}
+			handler(event)
+
+			return conn, err
+		}
+	}
 }
 
-func newDialerHook(host string, handler func(event DialerHookEvent)) func(network, address string) {
-	return func(network, address string) {
-		u, err := url.Parse(host)
-		if err != nil {
-			handler(DialerHookEvent{Msg: "url parsing failed", Err: err, Host: host, Address: address})
-			return
+type contextKeyDialHost struct{}
+
+// addressTransport returns a copy of the transport connecting to the address instead of the host, e.g. "10.0.0.1:443"
+// for "example.com:443". The host is still used for the Host header and TLS verification and dials to other
+// addresses, e.g. proxies, are not changed. The copy keeps a pool of connections of its own, since transports pool
+// connections by host. Returns false if the transport is not supported.
+func addressTransport(transport http.RoundTripper, host, address string) (http.RoundTripper, bool) {
+	switch t := transport.(type) {
+	case *http.Transport:
+		clone := t.Clone()
+		dial := clone.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		clone.DialContext = dialAddress(dial, host, address)
+		if clone.DialTLSContext != nil {
+			clone.DialTLSContext = dialAddress(clone.DialTLSContext, host, address)
+		}
+		return clone, true
+	case *http2.Transport:
+		if t.DialTLSContext == nil {
+			return nil, false
 		}
-		ips, err := net.LookupIP(u.Hostname())
-		if err != nil {
-			handler(DialerHookEvent{Msg: "dns lookup failed", Err: err, Host: host, Address: address})
-			return
+		return &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialAddress(func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return t.DialTLSContext(ctx, network, addr, cfg)
+				}, host, address)(ctx, network, addr)
+			},
+			TLSClientConfig:            t.TLSClientConfig,
+			DisableCompression:         t.DisableCompression,
+			AllowHTTP:                  t.AllowHTTP,
+			MaxHeaderListSize:          t.MaxHeaderListSize,
+			MaxReadFrameSize:           t.MaxReadFrameSize,
+			MaxDecoderHeaderTableSize:  t.MaxDecoderHeaderTableSize,
+			MaxEncoderHeaderTableSize:  t.MaxEncoderHeaderTableSize,
+			StrictMaxConcurrentStreams: t.StrictMaxConcurrentStreams,
+			IdleConnTimeout:            t.IdleConnTimeout,
+			ReadIdleTimeout:            t.ReadIdleTimeout,
+			PingTimeout:                t.PingTimeout,
+			WriteByteTimeout:           t.WriteByteTimeout,
+			CountError:                 t.CountError,
+		}, true
+	case *instrumentedTransport:
+		next, ok := addressTransport(t.next, host, address)
+		if !ok {
+			return nil, false
+		}
+		return &instrumentedTransport{next: next, in: t.in}, true
+	}
+	return nil, false
+}
+
+// dialAddress wraps dial functions connecting to the address when dialing the host. The host is kept in the context
+// so the connection stats are still kept by host.
+func dialAddress(dial dialFunc, host, address string) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != host {
+			return dial(ctx, network, addr)
 		}
-		handler(DialerHookEvent{Msg: "dns lookup", Err: nil, Host: host, Address: address, Lookups: ips})
+		return dial(context.WithValue(ctx, contextKeyDialHost{}, host), network, address)
 	}
 }
 
@@ -124,24 +292,11 @@ func (options *ConnOptions) sanitize() {
 	if options.connTimeout == 0 {
 		options.connTimeout = 3 * time.Second
 	}
-	if options.dialerControl == nil {
-		options.dialerControl = defaultDialerController
+	if options.maxHeaderBytes < 0 {
+		options.maxHeaderBytes = 0
 	}
 }
 
-type dialerControl struct {
-	hook func(network, address string)
-}
-
-func (dc *dialerControl) tap(network, address string, c syscall.RawConn) error {
-	if dc.hook != nil {
-		dc.hook(network, address)
-	}
-	return nil
-}
-
-var defaultDialerController = &dialerControl{}
-
 // NewConn creates a new HTTP Connection with decent defaults or overriding them with the provided options.
 func NewConn(opts ConnOptions) *http.Client {
 	opts.sanitize()
@@ -149,24 +304,57 @@ func NewConn(opts ConnOptions) *http.Client {
 	var dialer = &net.Dialer{
 		KeepAlive: opts.tcpKeepAlive,
 		Timeout:   opts.connTimeout, // default is 30s
-		Control:   opts.dialerControl.tap,
 	}
+	var dial dialFunc = dialer.DialContext
+	if opts.dialerHook != nil {
+		dial = opts.dialerHook(dial)
+	}
+	var instrument = func(transport http.RoundTripper) http.RoundTripper { return transport }
+	if opts.instrumented {
+		in := newConnInstrumentation(opts.traceHook)
+		dial = in.dial(dial)
+		instrument = func(transport http.RoundTripper) http.RoundTripper {
+			return &instrumentedTransport{next: transport, in: in}
+		}
+	}
+
+	if opts.h2c {
+		return &http.Client{
+			Transport: instrument(&http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dial(ctx, network, addr)
+				},
+				DisableCompression: opts.noCompression,
+				MaxHeaderListSize:  uint32(min(opts.maxHeaderBytes, math.MaxUint32)),
+				IdleConnTimeout:    opts.keepAlive,
+			}),
+			Timeout: opts.requestTimeout,
+		}
+	}
+
 	var transport = &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
-		MaxIdleConns:          opts.maxIdleConns,
-		MaxIdleConnsPerHost:   opts.maxIdleConnsPerHost,
-		IdleConnTimeout:       opts.keepAlive,
-		TLSHandshakeTimeout:   opts.connTimeout + 100*time.Millisecond,
-		ExpectContinueTimeout: opts.connTimeout + 100*time.Millisecond,
-		MaxConnsPerHost:       opts.maxConnsPerHost,
+		Proxy:                  http.ProxyFromEnvironment,
+		DialContext:            dial,
+		MaxIdleConns:           opts.maxIdleConns,
+		MaxIdleConnsPerHost:    opts.maxIdleConnsPerHost,
+		IdleConnTimeout:        opts.keepAlive,
+		TLSHandshakeTimeout:    opts.connTimeout + 100*time.Millisecond,
+		ExpectContinueTimeout:  opts.connTimeout + 100*time.Millisecond,
+		MaxConnsPerHost:        opts.maxConnsPerHost,
+		ForceAttemptHTTP2:      opts.http2,
+		ResponseHeaderTimeout:  opts.responseTimeout,
+		ReadBufferSize:         opts.readBufferSize,
+		WriteBufferSize:        opts.writeBufferSize,
+		MaxResponseHeaderBytes: opts.maxHeaderBytes,
+		DisableCompression:     opts.noCompression,
 	}
 	if opts.tls != nil {
 		transport.TLSClientConfig = opts.tls
 	}
 
 	return &http.Client{
-		Transport: transport,
+		Transport: instrument(transport),
 		Timeout:   opts.requestTimeout,
 	}
 }
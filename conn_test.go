@@ -0,0 +1,114 @@
+package webservice
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestNewConn(t *testing.T) {
+	t.Run("transport options", func(t *testing.T) {
+		conn := NewConn(DefaultConnOptions.
+			WithHTTP2(true).
+			WithResponseHeaderTimeout(2*time.Second).
+			WithBufferSizes(8<<10, 16<<10).
+			WithMaxResponseHeaderBytes(1 << 20).
+			WithCompressionDisabled(true))
+
+		transport, ok := conn.Transport.(*http.Transport)
+		require.True(t, ok)
+		assert.True(t, transport.ForceAttemptHTTP2)
+		assert.Equal(t, 2*time.Second, transport.ResponseHeaderTimeout)
+		assert.Equal(t, 8<<10, transport.ReadBufferSize)
+		assert.Equal(t, 16<<10, transport.WriteBufferSize)
+		assert.Equal(t, int64(1<<20), transport.MaxResponseHeaderBytes)
+		assert.True(t, transport.DisableCompression)
+	})
+
+	t.Run("h2c header limits", func(t *testing.T) {
+		transport := NewConn(DefaultConnOptions.WithH2C(true).WithMaxResponseHeaderBytes(1 << 40)).Transport.(*http2.Transport)
+		assert.Equal(t, uint32(math.MaxUint32), transport.MaxHeaderListSize)
+		transport = NewConn(DefaultConnOptions.WithH2C(true).WithMaxResponseHeaderBytes(-1)).Transport.(*http2.Transport)
+		assert.Zero(t, transport.MaxHeaderListSize, "default")
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		srv := NewServer(l.Addr().String(), ServerOptions{Listener: l, H2C: true, AccessLogDisabled: true})
+		srv.Echo.GET("/", func(c Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		startTestServer(t, srv)
+
+		conn := NewConn(DefaultConnOptions.WithH2C(true))
+		_, ok := conn.Transport.(*http2.Transport)
+		assert.True(t, ok)
+
+		res, err := conn.Get("http://" + l.Addr().String() + "/")
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, 2, res.ProtoMajor)
+	})
+}
+
+func TestConnOptions_WithDialerHook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+
+	var events []DialerHookEvent
+	conn := NewConn(DefaultConnOptions.WithDialerHook("svc", func(event DialerHookEvent) {
+		events = append(events, event)
+	}))
+
+	res, err := conn.Get("http://localhost:" + port)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Len(t, events, 1)
+	assert.Equal(t, "dial", events[0].Msg)
+	assert.Equal(t, "svc", events[0].Host)
+	assert.Equal(t, "localhost:"+port, events[0].Address)
+	assert.Equal(t, "tcp", events[0].Network)
+	assert.NotEmpty(t, events[0].Lookups, "addresses from the dial lookup")
+	assert.Contains(t, events[0].RemoteAddr, ":"+port)
+	assert.Positive(t, events[0].Duration)
+
+	res, err = conn.Get(srv.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Len(t, events, 2)
+	assert.Empty(t, events[1].Lookups, "no lookup for IP addresses")
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), events[1].RemoteAddr)
+
+	closed := closedAddress(t)
+	_, err = conn.Get(closed)
+	assert.Error(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "dial failed", events[2].Msg)
+	assert.Error(t, events[2].Err)
+	assert.Empty(t, events[2].RemoteAddr)
+
+	// endpoint addresses are dialed instead of the host of the URL
+	cli, err := NewBalancedClient(BalancingOptions{
+		Resolver: &testResolver{endpoints: []Endpoint{{URL: "http://service.local:" + port, Address: "127.0.0.1:" + port}}},
+	}, ClientOptions{Conn: conn})
+	require.NoError(t, err)
+	defer cli.Close()
+	_, _, err = cli.Request(context.Background(), http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, "dial", events[3].Msg)
+	assert.Equal(t, "127.0.0.1:"+port, events[3].Address)
+	assert.Equal(t, "127.0.0.1:"+port, events[3].RemoteAddr)
+}
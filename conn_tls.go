@@ -0,0 +1,235 @@
+package webservice
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+// TLSClientOptions data structure.
+// Create an empty one and use the builder functions to add in your options, then call Config to create the
+// tls.Config to be passed onto ConnOptions.WithTLSConfig.
+type TLSClientOptions struct {
+	certFile     string
+	keyFile      string
+	certPEM      []byte
+	keyPEM       []byte
+	reload       time.Duration
+	caFiles      []string
+	caPEMs       [][]byte
+	publicKeys   []string
+	certificates []string
+	minVersion   uint16
+	serverName   string
+	logger       *slog.Logger
+}
+
+// WithCertificateFiles sets the client certificate and key PEM files used for mutual TLS.
+func (options TLSClientOptions) WithCertificateFiles(certFile, keyFile string) TLSClientOptions {
+	options.certFile = certFile
+	options.keyFile = keyFile
+
+	return options
+}
+
+// WithCertificatePEM sets the client certificate and key, PEM encoded, used for mutual TLS.
+func (options TLSClientOptions) WithCertificatePEM(cert, key []byte) TLSClientOptions {
+	options.certPEM = cert
+	options.keyPEM = key
+
+	return options
+}
+
+// WithCertificateReload checks the client certificate files for changes, at most once every interval, when a
+// certificate is requested by a server. Only applies to certificates set with WithCertificateFiles.
+func (options TLSClientOptions) WithCertificateReload(interval time.Duration) TLSClientOptions {
+	options.reload = interval
+
+	return options
+}
+
+// WithRootCAFile adds the certificates in the PEM file to the CAs used for verifying servers.
+// The system CAs are used if no CAs are added.
+func (options TLSClientOptions) WithRootCAFile(path string) TLSClientOptions {
+	options.caFiles = append(options.caFiles[:len(options.caFiles):len(options.caFiles)], path)
+
+	return options
+}
+
+// WithRootCAPEM adds the PEM encoded certificates to the CAs used for verifying servers.
+// The system CAs are used if no CAs are added.
+func (options TLSClientOptions) WithRootCAPEM(data []byte) TLSClientOptions {
+	options.caPEMs = append(options.caPEMs[:len(options.caPEMs):len(options.caPEMs)], data)
+
+	return options
+}
+
+// WithPinnedPublicKeys only accepts servers whose certificate chain contains one of the public keys.
+// Pins are the base64 encoded SHA-256 hash of the DER encoded SubjectPublicKeyInfo, optionally prefixed by "sha256/".
+func (options TLSClientOptions) WithPinnedPublicKeys(pins ...string) TLSClientOptions {
+	options.publicKeys = append(options.publicKeys[:len(options.publicKeys):len(options.publicKeys)], pins...)
+
+	return options
+}
+
+// WithPinnedCertificates only accepts servers whose certificate chain contains one of the certificates.
+// Pins are the hex encoded SHA-256 fingerprint of the DER encoded certificate, colons are ignored.
+func (options TLSClientOptions) WithPinnedCertificates(fingerprints ...string) TLSClientOptions {
+	options.certificates = append(options.certificates[:len(options.certificates):len(options.certificates)], fingerprints...)
+
+	return options
+}
+
+// WithMinVersion sets the minimum TLS version, e.g. tls.VersionTLS13. Defaults to TLS 1.2.
+func (options TLSClientOptions) WithMinVersion(version uint16) TLSClientOptions {
+	options.minVersion = version
+
+	return options
+}
+
+// WithServerName overrides the server name used for verifying server certificates.
+func (options TLSClientOptions) WithServerName(name string) TLSClientOptions {
+	options.serverName = name
+
+	return options
+}
+
+// WithLogger sets the logger used for reporting certificate reloads.
+func (options TLSClientOptions) WithLogger(log *slog.Logger) TLSClientOptions {
+	options.logger = log
+
+	return options
+}
+
+// Config loads the certificates and creates the tls.Config.
+func (options TLSClientOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: options.minVersion,
+		ServerName: options.serverName,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	switch {
+	case options.certFile != "":
+		log := logger.NewSLogWrapper(slog.Default()).WithTags("http")
+		if options.logger != nil {
+			log = logger.NewSLogWrapper(options.logger)
+		}
+		certs := newCertReloader(options.certFile, options.keyFile, log)
+		if err := certs.Load(); err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = clientCertificateGetter(certs, options.reload)
+	case options.certPEM != nil:
+		cert, err := tls.X509KeyPair(options.certPEM, options.keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate; %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(options.caFiles) > 0 || len(options.caPEMs) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		for _, path := range options.caFiles {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file; %w", err)
+			}
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", path)
+			}
+		}
+		for _, data := range options.caPEMs {
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				return nil, errors.New("no certificates found in CA PEM")
+			}
+		}
+	}
+
+	if len(options.publicKeys) > 0 || len(options.certificates) > 0 {
+		verify, err := newPinVerifier(options.publicKeys, options.certificates)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyConnection = verify
+	}
+
+	return config, nil
+}
+
+// clientCertificateGetter returns the loaded client certificate, reloading it if modified at most once every interval.
+func clientCertificateGetter(certs *certReloader, interval time.Duration) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	var checked atomic.Int64
+	checked.Store(time.Now().UnixNano())
+
+	return func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if interval > 0 {
+			now := time.Now().UnixNano()
+			if last := checked.Load(); now-last >= int64(interval) && checked.CompareAndSwap(last, now) {
+				if err := certs.reloadIfModified(); err != nil {
+					certs.log.Errorf("tls: certificate reload failed: %+v", err)
+				}
+			}
+		}
+		return certs.GetCertificate(nil)
+	}
+}
+
+// newPinVerifier creates a tls.Config.VerifyConnection function which checks the verified chains, from the server
+// certificate up to the root CA, against the pinned public keys and certificates. Other certificates sent by the
+// server are ignored since they are not part of the trust path. Without verified chains, e.g. when verification is
+// skipped, only the server certificate is checked.
+// Regular certificate verification still applies.
+func newPinVerifier(publicKeys, certificates []string) (func(tls.ConnectionState) error, error) {
+	var keys = make(map[[sha256.Size]byte]bool)
+	for _, pin := range publicKeys {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid public key pin %q", pin)
+		}
+		keys[[sha256.Size]byte(hash)] = true
+	}
+	var certs = make(map[[sha256.Size]byte]bool)
+	for _, pin := range certificates {
+		hash, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate pin %q", pin)
+		}
+		certs[[sha256.Size]byte(hash)] = true
+	}
+
+	matches := func(chain []*x509.Certificate) bool {
+		for _, cert := range chain {
+			if keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] || certs[sha256.Sum256(cert.Raw)] {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(state tls.ConnectionState) error {
+		chains := state.VerifiedChains
+		if len(chains) == 0 && len(state.PeerCertificates) > 0 {
+			chains = [][]*x509.Certificate{state.PeerCertificates[:1]}
+		}
+		for _, chain := range chains {
+			if matches(chain) {
+				return nil
+			}
+		}
+		return errors.New("server certificate does not match any pin")
+	}, nil
+}
@@ -0,0 +1,133 @@
+package webservice
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSClientOptions(t *testing.T) {
+	var ca = newTestCert(t, nil, 1, x509.Certificate{Subject: pkix.Name{CommonName: "ca"}})
+	var server = newTestCert(t, &ca, 10, x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	var client = newTestCert(t, &ca, 20, x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+
+	var pool = x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	pair, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(options TLSClientOptions) (string, error) {
+		config, err := options.Config()
+		require.NoError(t, err)
+		conn := NewConn(DefaultConnOptions.WithTLSConfig(config))
+		conn.Transport.(*http.Transport).DisableKeepAlives = true
+		res, err := conn.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		var buf = make([]byte, 64)
+		n, _ := res.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	t.Run("pem", func(t *testing.T) {
+		cn, err := get(TLSClientOptions{}.WithCertificatePEM(client.certPEM, client.keyPEM).WithRootCAPEM(ca.certPEM))
+		assert.NoError(t, err)
+		assert.Equal(t, "client", cn)
+	})
+
+	t.Run("files with reload", func(t *testing.T) {
+		var dir = t.TempDir()
+		certFile, keyFile := client.writeFiles(t, dir)
+		caFile, _ := ca.writeFiles(t, t.TempDir())
+		options := TLSClientOptions{}.
+			WithCertificateFiles(certFile, keyFile).
+			WithCertificateReload(time.Nanosecond).
+			WithRootCAFile(caFile)
+
+		cn, err := get(options)
+		assert.NoError(t, err)
+		assert.Equal(t, "client", cn)
+
+		config, err := options.Config()
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		newTestCert(t, &ca, 21, x509.Certificate{Subject: pkix.Name{CommonName: "renewed"}}).writeFiles(t, dir)
+		cert, err := config.GetClientCertificate(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "renewed", cert.Leaf.Subject.CommonName)
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		_, err := get(TLSClientOptions{}.WithRootCAPEM(ca.certPEM))
+		assert.Error(t, err)
+	})
+
+	t.Run("pinning", func(t *testing.T) {
+		spki := sha256.Sum256(server.cert.RawSubjectPublicKeyInfo)
+		fingerprint := sha256.Sum256(ca.cert.Raw)
+		base := TLSClientOptions{}.WithCertificatePEM(client.certPEM, client.keyPEM).WithRootCAPEM(ca.certPEM)
+
+		_, err := get(base.WithPinnedPublicKeys("sha256/" + base64.StdEncoding.EncodeToString(spki[:])))
+		assert.NoError(t, err)
+		_, err = get(base.WithPinnedCertificates(hex.EncodeToString(fingerprint[:])))
+		assert.NoError(t, err)
+
+		other := sha256.Sum256([]byte("other"))
+		_, err = get(base.WithPinnedPublicKeys(base64.StdEncoding.EncodeToString(other[:])))
+		assert.ErrorContains(t, err, "does not match any pin")
+
+		_, err = base.WithPinnedPublicKeys("invalid").Config()
+		assert.Error(t, err)
+	})
+
+	t.Run("pinning ignores unverified certificates", func(t *testing.T) {
+		// a pinned certificate sent along a chain which does not include it must not match
+		other := newTestCert(t, nil, 2, x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+		fingerprint := sha256.Sum256(other.cert.Raw)
+		verify, err := newPinVerifier(nil, []string{hex.EncodeToString(fingerprint[:])})
+		require.NoError(t, err)
+
+		state := tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{server.cert, other.cert},
+			VerifiedChains:   [][]*x509.Certificate{{server.cert, ca.cert}},
+		}
+		assert.ErrorContains(t, verify(state), "does not match any pin")
+
+		state.VerifiedChains = nil
+		assert.ErrorContains(t, verify(state), "does not match any pin")
+		state.PeerCertificates = []*x509.Certificate{other.cert}
+		assert.NoError(t, verify(state), "server certificate pins match without verified chains")
+	})
+
+	t.Run("min version", func(t *testing.T) {
+		config, err := TLSClientOptions{}.Config()
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+		config, err = TLSClientOptions{}.WithMinVersion(tls.VersionTLS13).Config()
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	})
+}
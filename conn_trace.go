@@ -0,0 +1,222 @@
+package webservice
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnTrace of a request, reported by connections created with ConnOptions.WithInstrumentation.
+type ConnTrace struct {
+	Method string
+	// Host is the address of the request, including the port.
+	Host string
+	// RemoteAddr is the address the connection is connected to.
+	RemoteAddr string
+	// DNS, Connect and TLSHandshake are only set when a new connection was created for the request.
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// TimeToFirstByte since the request started.
+	TimeToFirstByte time.Duration
+	// Reused is true if the connection was used by previous requests.
+	Reused bool
+	// WasIdle is true if the connection was taken from the idle pool, for IdleTime.
+	WasIdle  bool
+	IdleTime time.Duration
+	// Err of the request, if any.
+	Err error
+	// Stats of the host after the request.
+	Stats HostConnStats
+}
+
+// HostConnStats are the connection stats of a host.
+type HostConnStats struct {
+	// Active is the number of requests using a connection, including reading the response.
+	Active int64 `json:"active"`
+	// Idle is the number of open connections not in use. HTTP/2 connections are idle if they have no requests.
+	Idle int64 `json:"idle"`
+	// Dials and DialErrors are the number of connections attempted and failed.
+	Dials      int64 `json:"dials"`
+	DialErrors int64 `json:"dial_errors"`
+}
+
+type hostConnCounters struct {
+	open       atomic.Int64
+	active     atomic.Int64
+	dials      atomic.Int64
+	dialErrors atomic.Int64
+}
+
+func (counters *hostConnCounters) stats() HostConnStats {
+	active := counters.active.Load()
+	return HostConnStats{
+		Active:     active,
+		Idle:       max(0, counters.open.Load()-active),
+		Dials:      counters.dials.Load(),
+		DialErrors: counters.dialErrors.Load(),
+	}
+}
+
+// connInstrumentation keeps the connection stats of each host and reports the trace of requests.
+type connInstrumentation struct {
+	hook  func(trace ConnTrace)
+	mu    sync.Mutex
+	hosts map[string]*hostConnCounters
+}
+
+func newConnInstrumentation(hook func(trace ConnTrace)) *connInstrumentation {
+	return &connInstrumentation{hook: hook, hosts: make(map[string]*hostConnCounters)}
+}
+
+func (in *connInstrumentation) host(address string) *hostConnCounters {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	counters, ok := in.hosts[address]
+	if !ok {
+		counters = &hostConnCounters{}
+		in.hosts[address] = counters
+	}
+	return counters
+}
+
+func (in *connInstrumentation) stats() map[string]HostConnStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	stats := make(map[string]HostConnStats, len(in.hosts))
+	for host, counters := range in.hosts {
+		stats[host] = counters.stats()
+	}
+	return stats
+}
+
+// dial wraps a dial function counting the dials and the open connections of each host.
+func (in *connInstrumentation) dial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host := address
+		if dialed, ok := ctx.Value(contextKeyDialHost{}).(string); ok {
+			host = dialed
+		}
+		counters := in.host(host)
+		counters.dials.Add(1)
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			counters.dialErrors.Add(1)
+			return nil, err
+		}
+		counters.open.Add(1)
+		return &countedConn{Conn: conn, counters: counters}, nil
+	}
+}
+
+// countedConn decrements the open connections of its host when closed.
+type countedConn struct {
+	net.Conn
+	counters *hostConnCounters
+	once     sync.Once
+}
+
+func (conn *countedConn) Close() error {
+	conn.once.Do(func() { conn.counters.open.Add(-1) })
+	return conn.Conn.Close()
+}
+
+// instrumentedTransport traces requests with httptrace.
+type instrumentedTransport struct {
+	next http.RoundTripper
+	in   *connInstrumentation
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var address = canonicalAddr(req)
+	var counters = t.in.host(address)
+	var start = time.Now()
+	var mu sync.Mutex
+	var trace = ConnTrace{Method: req.Method, Host: address}
+	var gotConn bool
+	var dnsStart, connectStart, tlsStart time.Time
+	var set = func(fn func()) {
+		mu.Lock()
+		fn()
+		mu.Unlock()
+	}
+
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { set(func() { dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(func() { trace.DNS = time.Since(dnsStart) }) },
+		ConnectStart: func(string, string) {
+			set(func() {
+				if connectStart.IsZero() {
+					connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone:       func(string, string, error) { set(func() { trace.Connect = time.Since(connectStart) }) },
+		TLSHandshakeStart: func() { set(func() { tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			set(func() { trace.TLSHandshake = time.Since(tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			set(func() {
+				if gotConn {
+					return
+				}
+				gotConn = true
+				counters.active.Add(1)
+				trace.Reused, trace.WasIdle, trace.IdleTime = info.Reused, info.WasIdle, info.IdleTime
+				trace.RemoteAddr = info.Conn.RemoteAddr().String()
+			})
+		},
+		GotFirstResponseByte: func() { set(func() { trace.TimeToFirstByte = time.Since(start) }) },
+	})
+
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+
+	// dials started by the request may still be running so only use copies from here on
+	mu.Lock()
+	result, active := trace, gotConn
+	mu.Unlock()
+
+	if active {
+		release := func() { counters.active.Add(-1) }
+		if err != nil {
+			release()
+		} else {
+			res.Body = &releaseOnClose{ReadCloser: res.Body, release: release}
+		}
+	}
+	if t.in.hook != nil {
+		result.Err = err
+		result.Stats = counters.stats()
+		t.in.hook(result)
+	}
+
+	return res, err
+}
+
+// canonicalAddr returns the host and port of the request URL, with the default port of the scheme if missing.
+// This matches the addresses dialed by the transports when not using a proxy.
+func canonicalAddr(req *http.Request) string {
+	if port := req.URL.Port(); port != "" {
+		return req.URL.Host
+	}
+	port := "80"
+	if req.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// ConnStats returns the connection stats of each host, if the connection was created with instrumentation, see
+// ConnOptions.WithInstrumentation.
+func (cli Client) ConnStats() map[string]HostConnStats {
+	if t, ok := cli.conn.Transport.(*instrumentedTransport); ok {
+		return t.in.stats()
+	}
+	return nil
+}
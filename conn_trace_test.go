@@ -0,0 +1,67 @@
+package webservice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnOptions_WithInstrumentation(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	var mu sync.Mutex
+	var traces []ConnTrace
+	conn := NewConn(DefaultConnOptions.WithTLSConfig(&tls.Config{RootCAs: pool}).WithInstrumentation(func(trace ConnTrace) {
+		mu.Lock()
+		traces = append(traces, trace)
+		mu.Unlock()
+	}))
+	cli := NewCustomClient(srv.URL, ClientOptions{Conn: conn})
+
+	for i := 0; i < 2; i++ {
+		status, _, err := cli.Request(context.Background(), http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.Len(t, traces, 2)
+	first, second := traces[0], traces[1]
+	assert.Equal(t, http.MethodGet, first.Method)
+	assert.Equal(t, host, first.Host)
+	assert.Equal(t, host, first.RemoteAddr)
+	assert.False(t, first.Reused)
+	assert.Positive(t, first.Connect)
+	assert.Positive(t, first.TLSHandshake)
+	assert.Positive(t, first.TimeToFirstByte)
+	assert.Equal(t, HostConnStats{Active: 1, Dials: 1}, first.Stats, "active until the body is closed")
+
+	assert.True(t, second.Reused)
+	assert.True(t, second.WasIdle)
+	assert.Zero(t, second.Connect)
+	assert.Zero(t, second.TLSHandshake)
+	assert.Positive(t, second.TimeToFirstByte)
+
+	assert.Equal(t, map[string]HostConnStats{host: {Idle: 1, Dials: 1}}, cli.ConnStats())
+
+	closed := closedAddress(t)
+	other := NewCustomClient(closed, ClientOptions{Conn: conn})
+	_, _, err := other.Request(context.Background(), http.MethodGet, "/", nil)
+	assert.Error(t, err)
+	assert.Error(t, traces[2].Err)
+	assert.Equal(t, HostConnStats{Dials: 1, DialErrors: 1}, other.ConnStats()[strings.TrimPrefix(closed, "http://")])
+
+	assert.Nil(t, NewClient(srv.URL).ConnStats(), "not instrumented")
+}
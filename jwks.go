@@ -0,0 +1,292 @@
+package webservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var errUnknownKey = errors.New("unknown key")
+
+// JSONWebKey is a verification key of a JSON Web Key Set.
+type JSONWebKey struct {
+	KeyID string
+	// Algorithm of the key, if restricted to one, e.g. RS256.
+	Algorithm string
+	// Key is an *rsa.PublicKey, an *ecdsa.PublicKey, an ed25519.PublicKey or a []byte secret.
+	Key any
+}
+
+// JWTKeySet provides the keys for verifying JWTs.
+type JWTKeySet interface {
+	// JWTKey returns the key with the ID. Tokens without a key ID use an empty ID.
+	JWTKey(ctx context.Context, keyID string) (JSONWebKey, error)
+}
+
+// JWKS is a static JSON Web Key Set.
+type JWKS []JSONWebKey
+
+// JWTKey returns the key with the ID. An empty ID matches the key if the set has a single key.
+func (set JWKS) JWTKey(ctx context.Context, keyID string) (JSONWebKey, error) {
+	for _, key := range set {
+		if key.KeyID == keyID {
+			return key, nil
+		}
+	}
+	if keyID == "" && len(set) == 1 {
+		return set[0], nil
+	}
+	return JSONWebKey{}, errUnknownKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set with RSA, P-256 EC, Ed25519 OKP and symmetric (oct) keys. Encryption keys and
+// keys of unsupported types are ignored.
+func ParseJWKS(data []byte) (JWKS, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS; %w", err)
+	}
+	set := JWKS{}
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK %q; %w", jwk.Kid, err)
+		}
+		if key != nil {
+			set = append(set, JSONWebKey{KeyID: jwk.Kid, Algorithm: jwk.Alg, Key: key})
+		}
+	}
+	return set, nil
+}
+
+// LoadJWKSFile reads and parses a JSON Web Key Set file.
+func LoadJWKSFile(path string) (JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file; %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+func (jwk jsonWebKey) parse() (any, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		k, err := decode(jwk.K)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, nil
+}
+
+// RemoteJWKSOptions settings.
+type RemoteJWKSOptions struct {
+	// URL of the JSON Web Key Set, e.g. "https://issuer.example.com/.well-known/jwks.json".
+	URL string
+	// RefreshInterval of the cached keys. Defaults to 1 hour.
+	RefreshInterval time.Duration
+	// MinRefreshInterval between fetches triggered by unknown key IDs, for following key rotations. Defaults to 1 minute.
+	MinRefreshInterval time.Duration
+	// HTTPClient used for fetching the keys. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// RemoteJWKS is a JWTKeySet fetched from a URL. The keys are cached and refreshed periodically in the background, or
+// when a token uses an unknown key ID. The cached keys are kept if a refresh fails.
+type RemoteJWKS struct {
+	options RemoteJWKSOptions
+	now     func() time.Time
+	mu      sync.Mutex
+	keys    JWKS
+	err     error
+	fetched time.Time
+	call    *jwksCall
+}
+
+type jwksCall struct {
+	done chan struct{}
+	keys JWKS
+	err  error
+}
+
+// NewRemoteJWKS creates a remote key set, the keys are fetched when first needed.
+func NewRemoteJWKS(options RemoteJWKSOptions) *RemoteJWKS {
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = time.Hour
+	}
+	if options.MinRefreshInterval <= 0 {
+		options.MinRefreshInterval = time.Minute
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteJWKS{options: options, now: time.Now}
+}
+
+// JWTKey returns the key with the ID. Stale key sets are refreshed in the background while the cached keys are used,
+// callers only wait for the key set if there is none yet or it is missing the key.
+func (set *RemoteJWKS) JWTKey(ctx context.Context, keyID string) (JSONWebKey, error) {
+	set.mu.Lock()
+	keys, age := set.keys, set.now().Sub(set.fetched)
+	if keys == nil {
+		// failed fetches also count, avoiding a fetch per request while the URL is failing
+		if !set.fetched.IsZero() && age < set.options.MinRefreshInterval {
+			err := set.err
+			set.mu.Unlock()
+			return JSONWebKey{}, err
+		}
+		call := set.refresh(ctx)
+		set.mu.Unlock()
+		keys, err := call.wait(ctx)
+		if err != nil {
+			return JSONWebKey{}, err
+		}
+		return keys.JWTKey(ctx, keyID)
+	}
+	if age >= set.options.RefreshInterval {
+		set.refresh(ctx)
+	}
+	key, err := keys.JWTKey(ctx, keyID)
+	if !errors.Is(err, errUnknownKey) || age < set.options.MinRefreshInterval {
+		set.mu.Unlock()
+		return key, err
+	}
+	call := set.refresh(ctx)
+	set.mu.Unlock()
+	if keys, err := call.wait(ctx); err == nil {
+		return keys.JWTKey(ctx, keyID)
+	}
+	return key, err
+}
+
+func (call *jwksCall) wait(ctx context.Context) (JWKS, error) {
+	select {
+	case <-call.done:
+		return call.keys, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh starts fetching the key set unless a fetch is already running. Must hold the lock.
+func (set *RemoteJWKS) refresh(ctx context.Context) *jwksCall {
+	if set.call != nil {
+		return set.call
+	}
+	call := &jwksCall{done: make(chan struct{})}
+	set.call = call
+	started := set.now()
+	// the fetch is shared with other callers so it must not be canceled with the caller's context
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		call.keys, call.err = set.fetch(ctx)
+		set.mu.Lock()
+		if call.err == nil {
+			set.keys = call.keys
+		}
+		set.err = call.err
+		set.fetched = started
+		set.call = nil
+		set.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// fetch the key set from the URL.
+func (set *RemoteJWKS) fetch(ctx context.Context) (JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, set.options.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request; %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := set.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS; %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching JWKS; status %d", res.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS; %w", err)
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,171 @@
+package webservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWK encodes a public key or secret as a JWK.
+func testJWK(t *testing.T, kid string, key any) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	case []byte:
+		return map[string]string{"kty": "oct", "kid": kid, "k": b64(k)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+func testJWKS(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	encryption := testJWK(t, "enc", &rsaKey.PublicKey)
+	encryption["use"] = "enc"
+	data := testJWKS(t,
+		testJWK(t, "rsa", &rsaKey.PublicKey),
+		testJWK(t, "ec", &ecKey.PublicKey),
+		testJWK(t, "ed", edPublic),
+		testJWK(t, "oct", []byte("secret")),
+		encryption,
+		map[string]string{"kty": "EC", "kid": "p384", "crv": "P-384"},
+	)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	set, err := LoadJWKSFile(path)
+	require.NoError(t, err)
+	require.Len(t, set, 4)
+	assert.True(t, rsaKey.PublicKey.Equal(set[0].Key))
+	assert.True(t, ecKey.PublicKey.Equal(set[1].Key))
+	assert.Equal(t, edPublic, set[2].Key)
+	assert.Equal(t, []byte("secret"), set[3].Key)
+
+	key, err := set.JWTKey(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.Equal(t, "ec", key.KeyID)
+	_, err = set.JWTKey(context.Background(), "")
+	assert.ErrorIs(t, err, errUnknownKey)
+	_, err = set[:1].JWTKey(context.Background(), "")
+	assert.NoError(t, err, "single key sets match tokens without key ID")
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.ErrorContains(t, err, "invalid EC key")
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	var fetches atomic.Int32
+	var current atomic.Value
+	current.Store(testJWKS(t, testJWK(t, "k1", []byte("one"))))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(current.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	set := NewRemoteJWKS(RemoteJWKSOptions{URL: srv.URL})
+	set.now = func() time.Time { return now }
+
+	key, err := set.JWTKey(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("one"), key.Key)
+	_, err = set.JWTKey(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, fetches.Load(), "cached")
+
+	// rotation: unknown keys only trigger a fetch after the minimum refresh interval
+	current.Store(testJWKS(t, testJWK(t, "k2", []byte("two"))))
+	_, err = set.JWTKey(context.Background(), "k2")
+	assert.ErrorIs(t, err, errUnknownKey)
+	assert.EqualValues(t, 1, fetches.Load())
+	now = now.Add(2 * time.Minute)
+	key, err = set.JWTKey(context.Background(), "k2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("two"), key.Key)
+	assert.EqualValues(t, 2, fetches.Load())
+
+	// stale keys are used while refreshing in the background, failed refreshes keep the cached keys
+	current.Store([]byte("invalid"))
+	now = now.Add(2 * time.Hour)
+	key, err = set.JWTKey(context.Background(), "k2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("two"), key.Key)
+	require.Eventually(t, func() bool { return !set.refreshing() && fetches.Load() == 3 }, time.Second, time.Millisecond)
+	key, err = set.JWTKey(context.Background(), "k2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("two"), key.Key)
+	assert.EqualValues(t, 3, fetches.Load())
+}
+
+func TestRemoteJWKSCancelled(t *testing.T) {
+	var fetches atomic.Int32
+	var release = make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(testJWKS(t, testJWK(t, "k1", []byte("one"))))
+	}))
+	defer srv.Close()
+
+	set := NewRemoteJWKS(RemoteJWKSOptions{URL: srv.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	var errs = make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := set.JWTKey(ctx, "k1")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// the fetch outlives the cancelled callers and its keys are used afterwards
+	close(release)
+	key, err := set.JWTKey(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("one"), key.Key)
+	assert.EqualValues(t, 1, fetches.Load())
+}
+
+// refreshing reports if a fetch is running.
+func (set *RemoteJWKS) refreshing() bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.call != nil
+}
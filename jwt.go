@@ -0,0 +1,73 @@
+package webservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// JWT signing algorithms.
+const (
+	JWTAlgRS256 = "RS256"
+	JWTAlgES256 = "ES256"
+	JWTAlgEdDSA = "EdDSA"
+	JWTAlgHS256 = "HS256"
+)
+
+// jwtAlgorithms maps the supported JWT algorithms to the matching signature algorithms.
+var jwtAlgorithms = map[string]string{
+	JWTAlgRS256: SigRSAv15SHA256,
+	JWTAlgES256: SigECDSAP256SHA256,
+	JWTAlgEdDSA: SigEd25519,
+	JWTAlgHS256: SigHMACSHA256,
+}
+
+// jwtAlgorithm returns the algorithm used with a signing key: RS256 for *rsa.PrivateKey, ES256 for P-256
+// *ecdsa.PrivateKey, EdDSA for ed25519.PrivateKey and HS256 for []byte secrets.
+func jwtAlgorithm(key any) (string, error) {
+	if _, err := keyAlgorithm(key); err != nil {
+		return "", err
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return JWTAlgRS256, nil
+	case *ecdsa.PrivateKey:
+		return JWTAlgES256, nil
+	case ed25519.PrivateKey:
+		return JWTAlgEdDSA, nil
+	case []byte:
+		return JWTAlgHS256, nil
+	}
+	return "", fmt.Errorf("unsupported JWT signing key type %T", key)
+}
+
+// signJWT encodes and signs a JWT with the algorithm matching the key, see jwtAlgorithm.
+func signJWT(key any, keyID string, claims map[string]any) (string, error) {
+	alg, err := jwtAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT header; %w", err)
+	}
+	encodedClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT claims; %w", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+
+	signature, err := signMessage(jwtAlgorithms[alg], key, []byte(input))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT; %w", err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -59,16 +59,24 @@ func (req StreamRequester) WithTimeout(timeout time.Duration) StreamRequester {
 }
 
 // Prepare the request and return the underlying *http.Request to be used in other connections.
+// Balanced clients prepare requests for their first healthy endpoint, see Client.FullURL.
 func (req StreamRequester) Prepare(ctx context.Context, method string, endpoint string, body io.Reader) (request *http.Request, err error) {
 	if err = req.validate(); err != nil {
 		return nil, err
 	}
 
-	hreq, err := http.NewRequestWithContext(ctx, method, req.cli.FullURL(endpoint), body)
+	return req.prepare(ctx, method, req.cli.FullURL(endpoint), body)
+}
+
+func (req StreamRequester) prepare(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	hreq, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request; %w", err)
 	}
-	hreq.Header = req.headers
+	hreq.Header = req.headers.Clone()
+	if deadline, ok := ctx.Deadline(); ok && req.cli.deadline {
+		hreq.Header.Set(HeaderRequestTimeout, formatTimeout(time.Until(deadline)))
+	}
 
 	for i := range req.cli.middlewares {
 		if hreq, err = req.cli.middlewares[i](ctx, hreq); err != nil {
@@ -83,11 +91,22 @@ func (req StreamRequester) Prepare(ctx context.Context, method string, endpoint
 // Callers must close the response.
 // Request timeout includes reading the response is included in the timeout yet that is out of the scope of this method.
 func (req StreamRequester) Do(ctx context.Context, method string, endpoint string, body io.Reader) (status int, response io.ReadCloser, err error) {
+	if req.cli.balancer != nil {
+		if err = req.validate(); err != nil {
+			return 0, nil, fmt.Errorf("error creating request; %w", err)
+		}
+		return req.doBalanced(ctx, method, endpoint, body)
+	}
 	hreq, err := req.Prepare(ctx, method, endpoint, body)
 	if err != nil {
 		return 0, nil, fmt.Errorf("error creating request; %w", err)
 	}
-	res, err := req.cli.conn.Do(hreq)
+	return req.send(hreq)
+}
+
+// send the request through the client's interceptors.
+func (req StreamRequester) send(hreq *http.Request) (status int, response io.ReadCloser, err error) {
+	res, err := req.cli.handler()(hreq)
 	if err != nil {
 		return 0, nil, fmt.Errorf("error running request; %w", err)
 	}
@@ -106,7 +125,8 @@ func (req StreamRequester) Context(ctx context.Context) (context.Context, contex
 
 // Requester ...
 type Requester struct {
-	core StreamRequester
+	core  StreamRequester
+	hedge bool
 }
 
 func (req Requester) WithTimeout(timeout time.Duration) Requester {
@@ -114,6 +134,14 @@ func (req Requester) WithTimeout(timeout time.Duration) Requester {
 	return req
 }
 
+// WithHedging sends a second attempt if no response arrives within the delay of the client's hedging policy,
+// returning whichever answers first. Only enable it for idempotent requests. Has no effect if the client has no
+// hedging policy, see ClientOptions.Hedging.
+func (req Requester) WithHedging(enabled bool) Requester {
+	req.hedge = enabled
+	return req
+}
+
 func (req Requester) WithHeader(key, value string) Requester {
 	req.core = req.core.WithHeader(key, value)
 	return req
@@ -141,6 +169,15 @@ func (req Requester) Do(ctx context.Context, method string, endpoint string, dat
 		defer cancel()
 	}
 
+	if req.hedge && req.core.cli.hedger != nil {
+		return req.core.cli.hedger.do(ctx, func(ctx context.Context) (int, []byte, error) {
+			return req.do(ctx, method, endpoint, data)
+		})
+	}
+	return req.do(ctx, method, endpoint, data)
+}
+
+func (req Requester) do(ctx context.Context, method string, endpoint string, data []byte) (status int, response []byte, err error) {
 	status, payload, err := req.core.Do(ctx, method, endpoint, bytes.NewBuffer(data))
 	if err != nil {
 		return 0, nil, err
@@ -181,6 +218,12 @@ func (req JSONRequester) WithTimeout(timeout time.Duration) JSONRequester {
 	return req
 }
 
+// WithHedging enables hedging, see Requester.WithHedging.
+func (req JSONRequester) WithHedging(enabled bool) JSONRequester {
+	req.core = req.core.WithHedging(enabled)
+	return req
+}
+
 func (req JSONRequester) WithHeader(key, value string) JSONRequester {
 	req.core = req.core.WithHeader(key, value)
 	return req
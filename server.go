@@ -2,17 +2,22 @@ package webservice
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"gitlab.com/vredens/go-logger/v2"
+	"golang.org/x/net/http2"
 )
 
 // Context is a server Request/Response context.
@@ -26,12 +31,34 @@ type ServerOptions struct {
 	IdleTimeout       time.Duration
 	TLSCertFile       string
 	TLSKeyFile        string
+	// TLSReloadInterval, when set, checks the TLS certificate and key files for changes and reloads them.
+	TLSReloadInterval time.Duration
+	// TLSGetCertificate, when set, provides the TLS certificates for the server instead of TLSCertFile and TLSKeyFile.
+	TLSGetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// TLSConfig is the base TLS configuration for the server. The other TLS options override its settings.
+	// The server certificates can be configured here instead of using TLSCertFile and TLSKeyFile.
+	TLSConfig *tls.Config
+	// TLSClientAuth policy for client certificates, e.g. tls.RequireAndVerifyClientCert for mutual TLS.
+	TLSClientAuth tls.ClientAuthType
+	// TLSClientCAFile is a PEM file with the CAs used for verifying client certificates.
+	// Defaults TLSClientAuth to tls.VerifyClientCertIfGiven if not set.
+	TLSClientCAFile string
+	// TLSMinVersion is the minimum TLS version accepted. Defaults to TLS 1.2.
+	TLSMinVersion uint16
+	// TLSCipherSuites is the list of enabled cipher suites for TLS 1.2 and lower. Uses Go's defaults if not set.
+	TLSCipherSuites []uint16
 	// Logger for internal messages and errors.
 	Logger *slog.Logger
+	// AuditLogger for admin actions. Defaults to Logger with an "audit" tag.
+	AuditLogger *slog.Logger
 	// AccessLogDisabled will not log any access logs if set to true.
 	AccessLogDisabled bool
 	// AccessLogDiscarder function should return true when no access log is to be written.
 	AccessLogDiscarder func(c Context) bool
+	// RedactQueryParams are the query parameters whose values are replaced in the URIs written to the access and audit
+	// logs, e.g. "api_key". Applies to every request, unlike APIKeyAuth.QueryParam which only applies to the requests
+	// handled by the API key middleware.
+	RedactQueryParams []string
 	// AccessLogMiddleware will override any AccessLog configuration if set.
 	// This is the second-last middleware called.
 	AccessLogMiddleware echo.MiddlewareFunc
@@ -39,19 +66,72 @@ type ServerOptions struct {
 	MetricsMiddleware echo.MiddlewareFunc
 	GzipDisabled      bool
 	GzipSkipper       func(c Context) bool
+	// PropagateDeadline sets the request context deadline from the HeaderRequestTimeout header sent by callers.
+	// Use NewDeadlineMiddleware for custom settings.
+	PropagateDeadline bool
+	// AdminAddress, when set, serves the admin, health and debug routes on a separate listener.
+	// Use this to keep operational routes out of the public API listener.
+	AdminAddress string
+	// AdminMiddlewares are the middlewares used by the admin listener.
+	// None of the public listener's middlewares are used by the admin listener.
+	AdminMiddlewares []echo.MiddlewareFunc
+	// AdminAccessLogEnabled will write access logs for the admin listener, which are disabled by default.
+	AdminAccessLogEnabled bool
+	// Listener, if set, is used instead of creating a new one for the server address.
+	// The server address is only used for logging purposes.
+	Listener net.Listener
+	// AdminListener, if set, is used instead of creating a new one for the admin address.
+	AdminListener net.Listener
+	// UnixSocket options for addresses with the "unix:" prefix.
+	UnixSocket UnixSocketOptions
+	// RestartSignal, when set, triggers a Restart each time the process receives it, e.g. syscall.SIGUSR2.
+	RestartSignal os.Signal
+	// RestartCommand is the command, and its arguments, executed on Restart.
+	// Defaults to the current executable with the same arguments.
+	RestartCommand []string
+	// RestartTimeout is the maximum time to wait for the new process to be ready. Defaults to 30 seconds.
+	RestartTimeout time.Duration
+	// H2C enables HTTP/2 over cleartext connections, both with prior knowledge and upgrade, on the public listener.
+	// Only use this behind trusted networks, e.g. a service mesh.
+	H2C bool
+	// HTTP2 settings for HTTP/2 connections, over TLS or h2c.
+	HTTP2 HTTP2Options
 }
 
 // Server is a wrapper around echo.Echo.
 type Server struct {
-	Echo    *echo.Echo
-	log     logger.SLogger
-	address string
-	running uint32
-	tls     struct {
-		enabled  int32
-		certFile string
-		keyFile  string
+	Echo *echo.Echo
+	// Admin is the echo instance for the admin listener.
+	// It is nil unless ServerOptions.AdminAddress is set.
+	Admin        *echo.Echo
+	log          logger.SLogger
+	audit        auditLogger
+	address      string
+	adminAddress string
+	running      uint32
+	listeners    struct {
+		public     net.Listener
+		admin      net.Listener
+		unixSocket UnixSocketOptions
+		mu         sync.Mutex
+		active     map[string]net.Listener
 	}
+	restart struct {
+		signal     os.Signal
+		command    []string
+		timeout    time.Duration
+		inProgress uint32
+	}
+	tls struct {
+		enabled        int32
+		certs          *certReloader
+		reload         time.Duration
+		getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		config         *tls.Config
+		err            error
+	}
+	http2 *http2.Server
+	h2c   bool
 }
 
 // NewServer ...
@@ -65,23 +145,35 @@ func NewServer(address string, opts ServerOptions) *Server {
 	} else {
 		srv.log = logger.NewSLogWrapper(slog.Default()).WithTags("http")
 	}
-
-	srv.Echo = echo.New()
-	srv.Echo.HideBanner = true
-	srv.Echo.HidePort = true
-	srv.Echo.HTTPErrorHandler = srv.webErrorHandler
-	srv.Echo.Server.ReadHeaderTimeout = opts.ReadHeaderTimeout
-	srv.Echo.Server.ReadTimeout = opts.ReadTimeout
-	srv.Echo.Server.WriteTimeout = opts.WriteTimeout
-	srv.Echo.Server.IdleTimeout = opts.IdleTimeout
-	srv.Echo.TLSServer.ReadHeaderTimeout = opts.ReadHeaderTimeout
-	srv.Echo.TLSServer.ReadTimeout = opts.ReadTimeout
-	srv.Echo.TLSServer.WriteTimeout = opts.WriteTimeout
-	srv.Echo.TLSServer.IdleTimeout = opts.IdleTimeout
+	if opts.AuditLogger != nil {
+		srv.audit.log = logger.NewSLogWrapper(opts.AuditLogger)
+	} else {
+		srv.audit.log = srv.log.WithTags("audit")
+	}
+	srv.audit.redact = opts.RedactQueryParams
+
+	srv.Echo = srv.newEcho(opts)
+	srv.configureHTTP2(opts)
+	srv.listeners.public = opts.Listener
+	srv.listeners.admin = opts.AdminListener
+	srv.listeners.unixSocket = opts.UnixSocket
+	srv.restart.signal = opts.RestartSignal
+	srv.restart.command = opts.RestartCommand
+	srv.restart.timeout = opts.RestartTimeout
+	if srv.restart.timeout <= 0 {
+		srv.restart.timeout = 30 * time.Second
+	}
 
 	if opts.TLSCertFile != "" {
-		srv.tls.certFile = opts.TLSCertFile
-		srv.tls.keyFile = opts.TLSKeyFile
+		srv.tls.certs = newCertReloader(opts.TLSCertFile, opts.TLSKeyFile, srv.log)
+		srv.tls.reload = opts.TLSReloadInterval
+		srv.tls.enabled = 1
+	}
+	if opts.TLSGetCertificate != nil {
+		srv.tls.getCertificate = opts.TLSGetCertificate
+		srv.tls.enabled = 1
+	}
+	if srv.tls.config, srv.tls.err = newServerTLSConfig(opts); srv.tls.config != nil || srv.tls.err != nil {
 		srv.tls.enabled = 1
 	}
 
@@ -92,11 +184,15 @@ func NewServer(address string, opts ServerOptions) *Server {
 		srv.Echo.Use(opts.AccessLogMiddleware)
 	} else if !opts.AccessLogDisabled {
 		srv.Echo.Use(NewAccessLogMiddleware(AccessLogger{
-			Logger:    srv.log.Logger,
-			Discarder: opts.AccessLogDiscarder,
+			Logger:            srv.log.Logger,
+			Discarder:         opts.AccessLogDiscarder,
+			RedactQueryParams: opts.RedactQueryParams,
 		}))
 	}
 	srv.Echo.Use(srv.recoverMiddleware())
+	if opts.PropagateDeadline {
+		srv.Echo.Use(NewDeadlineMiddleware(Deadline{}))
+	}
 
 	if !opts.GzipDisabled {
 		srv.Echo.Use(middleware.GzipWithConfig(middleware.GzipConfig{
@@ -104,12 +200,55 @@ func NewServer(address string, opts ServerOptions) *Server {
 		}))
 	}
 
+	if opts.AdminAddress != "" || opts.AdminListener != nil {
+		srv.adminAddress = opts.AdminAddress
+		srv.Admin = srv.newEcho(opts)
+		if opts.AdminAccessLogEnabled {
+			srv.Admin.Use(NewAccessLogMiddleware(AccessLogger{
+				Logger:            srv.log.Logger,
+				Discarder:         opts.AccessLogDiscarder,
+				RedactQueryParams: opts.RedactQueryParams,
+			}))
+		}
+		srv.Admin.Use(srv.recoverMiddleware())
+		srv.Admin.Use(opts.AdminMiddlewares...)
+	}
+
 	return srv
 }
 
+func (srv *Server) newEcho(opts ServerOptions) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = srv.webErrorHandler
+	e.Server.ReadHeaderTimeout = opts.ReadHeaderTimeout
+	e.Server.ReadTimeout = opts.ReadTimeout
+	e.Server.WriteTimeout = opts.WriteTimeout
+	e.Server.IdleTimeout = opts.IdleTimeout
+	e.TLSServer.ReadHeaderTimeout = opts.ReadHeaderTimeout
+	e.TLSServer.ReadTimeout = opts.ReadTimeout
+	e.TLSServer.WriteTimeout = opts.WriteTimeout
+	e.TLSServer.IdleTimeout = opts.IdleTimeout
+	return e
+}
+
+// AdminEcho returns the echo instance where the admin, health and debug routes are registered.
+// This is the admin listener if one is configured, otherwise it is the public one.
+// Use it for registering other operational routes, such as metrics.
+func (srv *Server) AdminEcho() *echo.Echo {
+	if srv.Admin != nil {
+		return srv.Admin
+	}
+	return srv.Echo
+}
+
 // RegisterAdminRoutes registers preset handlers for <prefix>/admin routes.
-func (srv *Server) RegisterAdminRoutes(prefix string) {
-	srv.Echo.POST(prefix+"/admin/shutdown", srv.handleShutdown)
+// Every request to these routes is written to the audit log, including the ones rejected by the provided middlewares.
+// You should always protect these routes with one of the authentication middlewares, e.g. NewBearerTokenMiddleware.
+func (srv *Server) RegisterAdminRoutes(prefix string, middlewares ...echo.MiddlewareFunc) {
+	group := srv.AdminEcho().Group(prefix+"/admin", append([]echo.MiddlewareFunc{srv.audit.Middleware}, middlewares...)...)
+	group.POST("/shutdown", srv.handleShutdown)
 }
 
 func (srv *Server) handleShutdown(c Context) error {
@@ -120,7 +259,7 @@ func (srv *Server) handleShutdown(c Context) error {
 
 // RegisterHealthRoutes registers preset handlers for <prefix>/health and <prefix>/info routes.
 func (srv *Server) RegisterHealthRoutes(prefix string) {
-	srv.Echo.GET(prefix+"/health", srv.handleGetApplicationQuickStatus)
+	srv.AdminEcho().GET(prefix+"/health", srv.handleGetApplicationQuickStatus)
 
 	// TODO: use debug.ReadBuildInfo()
 
@@ -130,7 +269,7 @@ func (srv *Server) RegisterHealthRoutes(prefix string) {
 	}
 	for _, path := range tryPaths {
 		if _, err := os.Stat(path); err == nil {
-			srv.Echo.File(prefix+"/info", path)
+			srv.AdminEcho().File(prefix+"/info", path)
 			break
 		}
 	}
@@ -146,33 +285,143 @@ func (srv *Server) Start() error {
 		return fmt.Errorf("server is not in pre-running state")
 	}
 
+	if err := srv.listen(); err != nil {
+		atomic.StoreUint32(&srv.running, 0)
+		return err
+	}
+	notifyHandoffReady()
+
+	if srv.restart.signal != nil {
+		defer srv.watchRestartSignal()()
+	}
+	if srv.tls.certs != nil && srv.tls.reload > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go srv.tls.certs.watch(srv.tls.reload, done)
+	}
+
+	var adminDone chan error
+	if srv.Admin != nil {
+		adminDone = make(chan error, 1)
+		go func() {
+			adminDone <- srv.startAdmin()
+		}()
+	}
+
 	srv.log.Infof("webserver: starting [address:%s]", srv.address)
 	err := srv.start()
 	srv.log.Infof("webserver: shutting down [address:%s]", srv.address)
 
+	if adminDone != nil {
+		if err := srv.Admin.Shutdown(context.Background()); err != nil {
+			srv.log.Errorf("webserver: failed to shutdown admin listener [address:%s]: %+v", srv.adminAddress, err)
+		}
+		if aerr := <-adminDone; errors.Is(err, http.ErrServerClosed) && !errors.Is(aerr, http.ErrServerClosed) {
+			err = aerr
+		}
+	}
+
 	atomic.StoreUint32(&srv.running, 0)
 
-	if err.Error() == "http: Server closed" {
+	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
 
 	return err
 }
 
+// listen creates the public and admin listeners, unless they were provided or inherited from a parent process.
+func (srv *Server) listen() error {
+	public, err := srv.listener(handoffPublic, srv.address, srv.listeners.public)
+	if err != nil {
+		return err
+	}
+
+	var admin net.Listener
+	if srv.Admin != nil {
+		if admin, err = srv.listener(handoffAdmin, srv.adminAddress, srv.listeners.admin); err != nil {
+			public.Close()
+			return err
+		}
+		srv.Admin.Listener = admin
+	}
+
+	if atomic.LoadInt32(&srv.tls.enabled) == 1 {
+		config, err := srv.tlsConfig()
+		if err != nil {
+			public.Close()
+			if admin != nil {
+				admin.Close()
+			}
+			return err
+		}
+		srv.Echo.TLSServer.TLSConfig = config
+		if err := srv.configureTLSHTTP2(); err != nil {
+			public.Close()
+			if admin != nil {
+				admin.Close()
+			}
+			return err
+		}
+		srv.Echo.TLSListener = tls.NewListener(public, srv.Echo.TLSServer.TLSConfig)
+	} else {
+		srv.Echo.Listener = public
+	}
+
+	srv.listeners.mu.Lock()
+	srv.listeners.active = map[string]net.Listener{handoffPublic: public}
+	if admin != nil {
+		srv.listeners.active[handoffAdmin] = admin
+	}
+	srv.listeners.mu.Unlock()
+
+	return nil
+}
+
+func (srv *Server) listener(name, address string, provided net.Listener) (net.Listener, error) {
+	if provided != nil {
+		return provided, nil
+	}
+	if l := inheritedListener(name); l != nil {
+		srv.log.Infof("webserver: using inherited listener [name:%s] [address:%s]", name, l.Addr())
+		return l, nil
+	}
+	return listen(address, srv.listeners.unixSocket)
+}
+
+// startAdmin runs the admin listener. Failing to start the admin listener stops the public one.
+func (srv *Server) startAdmin() error {
+	srv.log.Infof("webserver: starting admin [address:%s]", srv.adminAddress)
+	err := srv.Admin.StartServer(srv.Admin.Server)
+	if !errors.Is(err, http.ErrServerClosed) {
+		srv.log.Errorf("webserver: admin listener failed [address:%s]: %+v", srv.adminAddress, err)
+		go srv.Stop()
+	}
+	return err
+}
+
 func (srv *Server) start() error {
 	if atomic.LoadInt32(&srv.tls.enabled) == 1 {
-		return srv.Echo.StartTLS(srv.address, srv.tls.certFile, srv.tls.keyFile)
+		return srv.Echo.StartServer(srv.Echo.TLSServer)
+	}
+	if srv.h2c {
+		return srv.Echo.Server.Serve(srv.Echo.Listener)
 	}
 
-	return srv.Echo.Start(srv.address)
+	return srv.Echo.StartServer(srv.Echo.Server)
 }
 
-// Stop performs a clean shutdown of the server.
+// Stop performs a clean shutdown of the server, including the admin listener if one is configured.
 func (srv *Server) Stop() error {
 	if atomic.LoadUint32(&srv.running) != 1 {
 		return nil
 	}
-	return srv.Echo.Server.Shutdown(context.Background())
+	if srv.Admin != nil {
+		if err := srv.Admin.Shutdown(context.Background()); err != nil {
+			return err
+		}
+	}
+	return srv.Echo.Shutdown(context.Background())
 }
 
 func (srv *Server) webErrorHandler(err error, c Context) {
@@ -209,7 +458,7 @@ func (srv *Server) webErrorHandler(err error, c Context) {
 	}
 }
 
-func (srv Server) recoverMiddleware() echo.MiddlewareFunc {
+func (srv *Server) recoverMiddleware() echo.MiddlewareFunc {
 	var config middleware.RecoverConfig
 
 	if config.Skipper == nil {
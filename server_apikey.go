@@ -0,0 +1,188 @@
+package webservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderAPIKey is the default header of API keys.
+	HeaderAPIKey = "X-API-Key"
+
+	contextKeyAPIKey      = "webservice.api_key"
+	contextKeyAPIKeyParam = "webservice.api_key_param"
+)
+
+// ErrAPIKeyNotFound is returned by API key stores for unknown keys.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is the stored information of an API key, never the key itself.
+type APIKey struct {
+	// ID of the key, safe to be logged.
+	ID string
+	// Identity of the caller owning the key.
+	Identity string
+	// Scopes granted to the key.
+	Scopes []string
+	// ExpiresAt is the expiry of the key, zero if it does not expire.
+	ExpiresAt time.Time
+}
+
+// APIKeyStore looks up API keys by their hash, see HashAPIKey.
+type APIKeyStore interface {
+	// LookupAPIKey returns the key with the hash or ErrAPIKeyNotFound.
+	LookupAPIKey(ctx context.Context, hash string) (APIKey, error)
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of an API key, as stored by APIKeyStores. Only use randomly
+// generated keys with at least 128 bits of entropy, a fast hash is not suitable for low entropy secrets.
+func HashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+// MemoryAPIKeyStore is an APIKeyStore keeping the key hashes in memory.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore creates an empty store.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]APIKey)}
+}
+
+// Add a key by its hash, see HashAPIKey.
+func (store *MemoryAPIKeyStore) Add(hash string, key APIKey) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.keys[hash] = key
+}
+
+// Remove the key with the ID.
+func (store *MemoryAPIKeyStore) Remove(id string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for hash, key := range store.keys {
+		if key.ID == id {
+			delete(store.keys, hash)
+		}
+	}
+}
+
+// LookupAPIKey returns the key with the hash.
+func (store *MemoryAPIKeyStore) LookupAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if key, ok := store.keys[hash]; ok {
+		return key, nil
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+// APIKeyAuth settings.
+type APIKeyAuth struct {
+	// Store of the hashed keys.
+	Store APIKeyStore
+	// Header containing the key. Defaults to X-API-Key.
+	Header string
+	// QueryParam containing the key, used if the header is missing. Keys are only read from the query if set.
+	// The key is redacted from the URIs written to the access log of the requests handled by the middleware. Add it
+	// to ServerOptions.RedactQueryParams for redacting it from the other requests too, e.g. the ones not found.
+	QueryParam string
+	// RequiredScopes which must all be granted to the key, otherwise the request is forbidden.
+	RequiredScopes []string
+	// Skipper defines a function to skip the middleware.
+	Skipper func(c Context) bool
+}
+
+// NewAPIKeyMiddleware only accepts requests with a valid API key from the store. Requests with a missing, unknown or
+// expired key are rejected with 401 and requests with keys missing the required scopes are rejected with 403.
+// The key is available through GetAPIKey, its identity is set as the request Identity and its ID is written to the
+// access log.
+func NewAPIKeyMiddleware(params APIKeyAuth) echo.MiddlewareFunc {
+	if params.Header == "" {
+		params.Header = HeaderAPIKey
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			if params.Skipper != nil && params.Skipper(c) {
+				return next(c)
+			}
+			if params.QueryParam != "" {
+				c.Set(contextKeyAPIKeyParam, params.QueryParam)
+			}
+			secret := c.Request().Header.Get(params.Header)
+			if secret == "" && params.QueryParam != "" {
+				secret = c.QueryParam(params.QueryParam)
+			}
+			if secret == "" {
+				return errUnauthorized
+			}
+
+			key, err := params.Store.LookupAPIKey(c.Request().Context(), HashAPIKey(secret))
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				return errUnauthorized
+			}
+			if err != nil {
+				return NewError(http.StatusServiceUnavailable, errors.New("failed to verify api key"))
+			}
+			if !key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt) {
+				return errUnauthorized
+			}
+			c.Set(contextKeyAPIKey, key)
+			for _, scope := range params.RequiredScopes {
+				if !slices.Contains(key.Scopes, scope) {
+					return errForbidden
+				}
+			}
+
+			setIdentity(c, "apikey:"+key.Identity)
+			return next(c)
+		}
+	}
+}
+
+// GetAPIKey returns the key set by the API key middleware.
+func GetAPIKey(c Context) (APIKey, bool) {
+	key, ok := c.Get(contextKeyAPIKey).(APIKey)
+	return key, ok
+}
+
+// loggedURI returns the request URI with the query parameters and the API key query parameter, if any, redacted.
+func loggedURI(c Context, params []string) string {
+	uri := c.Request().RequestURI
+	for _, param := range params {
+		uri = redactQueryParam(uri, param)
+	}
+	if param, ok := c.Get(contextKeyAPIKeyParam).(string); ok {
+		uri = redactQueryParam(uri, param)
+	}
+	return uri
+}
+
+// redactQueryParam replaces the values of a query parameter of a request URI.
+func redactQueryParam(uri, param string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	if !query.Has(param) {
+		return uri
+	}
+	for i := range query[param] {
+		query[param][i] = "xxxxx"
+	}
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,127 @@
+package webservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+type failingAPIKeyStore struct{}
+
+func (failingAPIKeyStore) LookupAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	return APIKey{}, errors.New("connection refused")
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	store := NewMemoryAPIKeyStore()
+	store.Add(HashAPIKey("k1-secret"), APIKey{ID: "k1", Identity: "billing", Scopes: []string{"read"}})
+	store.Add(HashAPIKey("k2-secret"), APIKey{ID: "k2", Identity: "reports", ExpiresAt: time.Now().Add(-time.Minute)})
+	store.Add(HashAPIKey("k3-secret"), APIKey{ID: "k3", Identity: "admin", Scopes: []string{"read", "write"}})
+
+	b := &bytes.Buffer{}
+	srv := NewServer(":0", ServerOptions{
+		Logger:            slog.New(logger.NewSLogHandler(logger.New(logger.ConfigWriter(b)).Spawn(), slog.LevelDebug)),
+		RedactQueryParams: []string{"api_key"},
+	})
+	srv.Echo.GET("/read", func(c Context) error {
+		key, ok := GetAPIKey(c)
+		require.True(t, ok)
+		return c.String(http.StatusOK, Identity(c)+" "+key.ID)
+	}, NewAPIKeyMiddleware(APIKeyAuth{Store: store, QueryParam: "api_key"}))
+	srv.Echo.GET("/write", func(c Context) error {
+		return c.NoContent(http.StatusOK)
+	}, NewAPIKeyMiddleware(APIKeyAuth{Store: store, RequiredScopes: []string{"write"}}))
+	srv.Echo.GET("/failing", func(c Context) error {
+		return c.NoContent(http.StatusOK)
+	}, NewAPIKeyMiddleware(APIKeyAuth{Store: failingAPIKeyStore{}}))
+
+	request := func(target, key string) *httptest.ResponseRecorder {
+		b.Reset()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set(HeaderAPIKey, key)
+		}
+		return serve(srv, req)
+	}
+
+	t.Run("header", func(t *testing.T) {
+		rec := request("/read", "k1-secret")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "apikey:billing k1", rec.Body.String())
+		assert.Contains(t, b.String(), `"key_id":"k1"`)
+		assert.Contains(t, b.String(), `"identity":"apikey:billing"`)
+		assert.NotContains(t, b.String(), "k1-secret")
+	})
+
+	t.Run("query param", func(t *testing.T) {
+		rec := request("/read?api_key=k1-secret&a=b", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, b.String(), `"key_id":"k1"`)
+		assert.Contains(t, b.String(), "api_key=xxxxx")
+		assert.NotContains(t, b.String(), "k1-secret")
+
+		rec = request("/write?api_key=k3-secret", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "query param not enabled")
+	})
+
+	t.Run("query param not handled", func(t *testing.T) {
+		rec := request("/missing?api_key=k1-secret", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Contains(t, b.String(), "api_key=xxxxx")
+		assert.NotContains(t, b.String(), "k1-secret")
+
+		req := httptest.NewRequest(http.MethodPost, "/read?api_key=k1-secret", nil)
+		b.Reset()
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(srv, req).Code)
+		assert.Contains(t, b.String(), "api_key=xxxxx")
+		assert.NotContains(t, b.String(), "k1-secret")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		rec := request("/read?api_key=nope", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.NotContains(t, b.String(), "nope")
+		assert.NotContains(t, b.String(), "key_id")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("/read", "").Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("/read", "k2-secret").Code)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		rec := request("/write", "k1-secret")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, b.String(), `"key_id":"k1"`)
+		assert.Equal(t, http.StatusOK, request("/write", "k3-secret").Code)
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		store.Remove("k3")
+		assert.Equal(t, http.StatusUnauthorized, request("/write", "k3-secret").Code)
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		rec := request("/failing", "k1-secret")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "connection refused")
+	})
+}
+
+func TestRedactQueryParam(t *testing.T) {
+	assert.Equal(t, "/path?a=b&key=xxxxx", redactQueryParam("/path?key=secret&a=b", "key"))
+	assert.Equal(t, "/path?a=b", redactQueryParam("/path?a=b", "key"))
+	assert.Equal(t, "/path", redactQueryParam("/path", "key"))
+}
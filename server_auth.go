@@ -0,0 +1,444 @@
+package webservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+const (
+	// HeaderSignature contains the hex encoded HMAC-SHA256 signature of a request.
+	HeaderSignature = "X-Signature"
+	// HeaderSignatureKey contains the ID of the key used for signing a request.
+	HeaderSignatureKey = "X-Signature-Key"
+	// HeaderSignatureTimestamp contains the unix timestamp, in seconds, of when the request was signed.
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+	// HeaderSignatureHeaders contains the comma separated names of the headers included in the signature of a request.
+	HeaderSignatureHeaders = "X-Signature-Headers"
+
+	contextKeyIdentity     = "webservice.identity"
+	contextKeyPeerIdentity = "webservice.peer_identity"
+)
+
+var (
+	errUnauthorized = NewError(http.StatusUnauthorized, errors.New("unauthorized"))
+	errForbidden    = NewError(http.StatusForbidden, errors.New("forbidden"))
+)
+
+// Identity returns the caller identity set by one of the authentication middlewares.
+// Returns an empty string for anonymous requests.
+func Identity(c Context) string {
+	if id, ok := c.Get(contextKeyIdentity).(string); ok {
+		return id
+	}
+	return ""
+}
+
+func setIdentity(c Context, id string) {
+	c.Set(contextKeyIdentity, id)
+}
+
+// NewBearerTokenMiddleware only accepts requests with an "Authorization: Bearer <token>" header matching one of the
+// provided tokens. The tokens map has the identity of the caller as key and the token as value.
+// Tokens are compared by their SHA-256 hash, always against all of them, so the time taken does not reveal which one
+// matched nor their lengths.
+func NewBearerTokenMiddleware(tokens map[string]string) echo.MiddlewareFunc {
+	type hashedToken struct {
+		id     string
+		digest [sha256.Size]byte
+	}
+	hashed := make([]hashedToken, 0, len(tokens))
+	for id, token := range tokens {
+		hashed = append(hashed, hashedToken{id: id, digest: sha256.Sum256([]byte(token))})
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			token, ok := bearerToken(c.Request())
+			if !ok {
+				return errUnauthorized
+			}
+			digest := sha256.Sum256([]byte(token))
+			match := -1
+			for i := range hashed {
+				match = subtle.ConstantTimeSelect(subtle.ConstantTimeCompare(digest[:], hashed[i].digest[:]), i, match)
+			}
+			if match < 0 {
+				return errUnauthorized
+			}
+			setIdentity(c, "token:"+hashed[match].id)
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get(echo.HeaderAuthorization)
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, token != ""
+}
+
+// HMACAuth settings.
+type HMACAuth struct {
+	// Keys maps key IDs to their shared secrets.
+	Keys map[string][]byte
+	// MaxSkew is the maximum difference allowed between the signature timestamp and the server clock.
+	// Defaults to 5 minutes.
+	MaxSkew time.Duration
+	// MaxBodySize is the maximum number of bytes read from the request body for verifying the signature.
+	// Defaults to 1MB.
+	MaxBodySize int64
+	// RequiredHeaders are the headers which must be included in the signature, e.g. "Host" or "Content-Type".
+	RequiredHeaders []string
+}
+
+// NewHMACAuthMiddleware only accepts requests signed with SignHMACRequest or NewHMACSigningMiddleware using one of
+// the configured keys.
+func NewHMACAuthMiddleware(params HMACAuth) echo.MiddlewareFunc {
+	if params.MaxSkew <= 0 {
+		params.MaxSkew = 5 * time.Minute
+	}
+	if params.MaxBodySize <= 0 {
+		params.MaxBodySize = 1 << 20
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			req := c.Request()
+			keyID := req.Header.Get(HeaderSignatureKey)
+			secret, ok := params.Keys[keyID]
+			if !ok {
+				return errUnauthorized
+			}
+			signature, err := hex.DecodeString(req.Header.Get(HeaderSignature))
+			if err != nil || len(signature) == 0 {
+				return errUnauthorized
+			}
+			ts, err := strconv.ParseInt(req.Header.Get(HeaderSignatureTimestamp), 10, 64)
+			if err != nil {
+				return errUnauthorized
+			}
+			if skew := time.Since(time.Unix(ts, 0)); skew > params.MaxSkew || skew < -params.MaxSkew {
+				return errUnauthorized
+			}
+			var headers []string
+			if signed := req.Header.Get(HeaderSignatureHeaders); signed != "" {
+				for _, name := range strings.Split(signed, ",") {
+					headers = append(headers, strings.ToLower(strings.TrimSpace(name)))
+				}
+			}
+			for _, name := range params.RequiredHeaders {
+				if !slices.Contains(headers, strings.ToLower(name)) {
+					return errUnauthorized
+				}
+			}
+			body, err := readRequestBody(req, params.MaxBodySize)
+			if err != nil {
+				return err
+			}
+			if !hmac.Equal(signature, hmacSignature(secret, req, ts, body, headers)) {
+				return errUnauthorized
+			}
+			setIdentity(c, "hmac:"+keyID)
+			return next(c)
+		}
+	}
+}
+
+// readRequestBody reads up to maxSize bytes of the request body, replacing it so it can be read again.
+func readRequestBody(req *http.Request, maxSize int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, fmt.Errorf("failed to read request body; %w", err))
+	}
+	if int64(len(body)) > maxSize {
+		return nil, NewError(http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// HMACSigning settings.
+type HMACSigning struct {
+	// KeyID and Secret used for signing requests.
+	KeyID  string
+	Secret []byte
+	// Headers included in the signature, e.g. "Host" or "Content-Type".
+	Headers []string
+}
+
+// NewHMACSigningMiddleware signs the requests of a client for the HMAC authentication middleware, as SignHMACRequest
+// does, also including the configured headers in the signature.
+func NewHMACSigningMiddleware(params HMACSigning) RequestMiddleware {
+	return func(ctx context.Context, req *http.Request) (*http.Request, error) {
+		return req, signHMACRequest(req, params.KeyID, params.Secret, params.Headers, time.Now())
+	}
+}
+
+// SignHMACRequest adds the signature headers expected by the HMAC authentication middleware to a request.
+// The request body is read and replaced so it can still be sent.
+func SignHMACRequest(req *http.Request, keyID string, secret []byte, now time.Time) error {
+	return signHMACRequest(req, keyID, secret, nil, now)
+}
+
+func signHMACRequest(req *http.Request, keyID string, secret []byte, headers []string, now time.Time) error {
+	body, err := bufferRequestBody(req)
+	if err != nil {
+		return err
+	}
+	ts := now.Unix()
+	req.Header.Set(HeaderSignatureKey, keyID)
+	req.Header.Set(HeaderSignatureTimestamp, strconv.FormatInt(ts, 10))
+	if len(headers) > 0 {
+		names := make([]string, len(headers))
+		for i := range headers {
+			names[i] = strings.ToLower(headers[i])
+		}
+		headers = names
+		req.Header.Set(HeaderSignatureHeaders, strings.Join(headers, ","))
+	}
+	req.Header.Set(HeaderSignature, hex.EncodeToString(hmacSignature(secret, req, ts, body, headers)))
+	return nil
+}
+
+// bufferRequestBody reads the body of a request being sent, replacing it so it can still be sent.
+func bufferRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body; %w", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// hmacSignature computes HMAC-SHA256(secret, "<timestamp>\n<method>\n<request uri>\n<hex sha256(body)>"), followed by a
+// "\n<name>:<value>" line for each of the signed headers.
+func hmacSignature(secret []byte, req *http.Request, ts int64, body []byte, headers []string) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(req.Method))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(req.URL.RequestURI()))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(hex.EncodeToString(digest[:])))
+	for _, name := range headers {
+		mac.Write([]byte{'\n'})
+		mac.Write([]byte(name + ":" + headerValue(req, name)))
+	}
+	return mac.Sum(nil)
+}
+
+// headerValue returns the trimmed values of a header joined by commas, or the host of the request for "host".
+func headerValue(req *http.Request, name string) string {
+	if strings.EqualFold(name, "host") {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	values := slices.Clone(req.Header.Values(name))
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return strings.Join(values, ",")
+}
+
+// PeerIdentity of a client authenticated with a verified TLS client certificate.
+type PeerIdentity struct {
+	// Subject is the certificate's distinguished name, e.g. "CN=admin,O=Example".
+	Subject    string
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	Emails     []string
+	// SPIFFEID is the certificate's spiffe:// URI SAN, if any.
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+func newPeerIdentity(state *tls.ConnectionState) (PeerIdentity, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return PeerIdentity{}, false
+	}
+	cert := state.VerifiedChains[0][0]
+	id := PeerIdentity{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Certificate: cert,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+	return id, true
+}
+
+// String returns the prefixed SPIFFE ID if available, e.g. "spiffe:spiffe://example.org/billing", or the prefixed
+// common name otherwise, e.g. "cert:billing".
+func (id PeerIdentity) String() string {
+	if id.SPIFFEID != "" {
+		return "spiffe:" + id.SPIFFEID
+	}
+	return "cert:" + id.CommonName
+}
+
+// GetPeerIdentity returns the identity set by the NewPeerIdentityMiddleware.
+func GetPeerIdentity(c Context) (PeerIdentity, bool) {
+	id, ok := c.Get(contextKeyPeerIdentity).(PeerIdentity)
+	return id, ok
+}
+
+// NewPeerIdentityMiddleware exposes the identity of clients with a verified TLS client certificate.
+// Use GetPeerIdentity for reading it in handlers. The identity is also set as the request Identity.
+// If required is true then requests without a verified client certificate are rejected.
+func NewPeerIdentityMiddleware(required bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			id, ok := newPeerIdentity(c.Request().TLS)
+			if !ok {
+				if required {
+					return errUnauthorized
+				}
+				return next(c)
+			}
+			c.Set(contextKeyPeerIdentity, id)
+			setIdentity(c, id.String())
+			return next(c)
+		}
+	}
+}
+
+// NewClientCertMiddleware only accepts requests whose verified TLS client certificate has a subject matching one of
+// the provided subjects. A subject matches either the certificate's Common Name or its full distinguished name
+// (e.g. "CN=admin,O=Example").
+// The server must be configured to request and verify client certificates.
+func NewClientCertMiddleware(subjects ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			id, ok := newPeerIdentity(c.Request().TLS)
+			if !ok {
+				return errUnauthorized
+			}
+			for _, subject := range subjects {
+				if subject == id.CommonName || subject == id.Subject {
+					setIdentity(c, "cert:"+id.CommonName)
+					return next(c)
+				}
+			}
+			return errForbidden
+		}
+	}
+}
+
+// NewIPAllowListMiddleware only accepts requests from the provided IPs or CIDR ranges.
+// The client IP is taken from the connection's remote address unless an IPExtractor is configured in echo, in which
+// case c.RealIP() is used. This avoids trusting client provided X-Forwarded-For headers by default.
+func NewIPAllowListMiddleware(cidrs ...string) (echo.MiddlewareFunc, error) {
+	var prefixes = make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q; %w", cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q; %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			addr, err := netip.ParseAddr(clientIP(c))
+			if err != nil {
+				return errForbidden
+			}
+			addr = addr.Unmap()
+			for _, prefix := range prefixes {
+				if prefix.Contains(addr) {
+					return next(c)
+				}
+			}
+			return errForbidden
+		}
+	}, nil
+}
+
+func clientIP(c Context) string {
+	if c.Echo().IPExtractor != nil {
+		return c.RealIP()
+	}
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+	return host
+}
+
+// auditLogger writes a log entry for every request it handles, including the ones rejected by authentication.
+type auditLogger struct {
+	log    logger.SLogger
+	redact []string
+}
+
+func (audit auditLogger) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c Context) (err error) {
+		if err = next(c); err != nil {
+			c.Error(err)
+		}
+
+		req, uri := c.Request(), loggedURI(c, audit.redact)
+		l := audit.log.With(
+			slog.String("action", c.Path()),
+			slog.String("method", req.Method),
+			slog.String("uri", uri),
+			slog.String("remote_ip", clientIP(c)),
+			slog.String("identity", Identity(c)),
+			slog.Int("status", c.Response().Status),
+		)
+		if err != nil {
+			l.Warnf("admin: %s %s failed: %v", req.Method, uri, err)
+			return err
+		}
+		l.Infof("admin: %s %s", req.Method, uri)
+
+		return nil
+	}
+}
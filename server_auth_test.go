@@ -0,0 +1,200 @@
+package webservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+func newAuthTestServer(middlewares ...echo.MiddlewareFunc) *Server {
+	srv := NewServer(":0", ServerOptions{AccessLogDisabled: true})
+	srv.Echo.POST("/test", func(c Context) error {
+		return c.String(http.StatusOK, Identity(c))
+	}, middlewares...)
+	return srv
+}
+
+func serve(srv *Server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBearerTokenMiddleware(t *testing.T) {
+	srv := newAuthTestServer(NewBearerTokenMiddleware(map[string]string{"ops": "s3cr3t", "ci": "an0th3r"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+
+	req.Header.Set("Authorization", "Bearer nope")
+	assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+
+	req.Header.Set("Authorization", "Bearer s3cr")
+	assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code, "prefix of a token")
+
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	rec := serve(srv, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "token:ops", rec.Body.String())
+
+	req.Header.Set("Authorization", "Bearer an0th3r")
+	rec = serve(srv, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "token:ci", rec.Body.String())
+}
+
+func TestHMACAuthMiddleware(t *testing.T) {
+	srv := newAuthTestServer(NewHMACAuthMiddleware(HMACAuth{Keys: map[string][]byte{"k1": []byte("secret")}}))
+
+	t.Run("valid", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test?a=b", strings.NewReader("payload"))
+		assert.NoError(t, SignHMACRequest(req, "k1", []byte("secret"), time.Now()))
+		rec := serve(srv, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hmac:k1", rec.Body.String())
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload"))
+		assert.NoError(t, SignHMACRequest(req, "k1", []byte("secret"), time.Now()))
+		req.Body = http.NoBody
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		assert.NoError(t, SignHMACRequest(req, "k1", []byte("other"), time.Now()))
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		assert.NoError(t, SignHMACRequest(req, "k1", []byte("secret"), time.Now().Add(-time.Hour)))
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+}
+
+func TestHMACSigningMiddleware(t *testing.T) {
+	srv := newAuthTestServer(NewHMACAuthMiddleware(HMACAuth{
+		Keys:            map[string][]byte{"k1": []byte("secret")},
+		RequiredHeaders: []string{"Host", "Content-Type"},
+	}))
+	sign := NewHMACSigningMiddleware(HMACSigning{KeyID: "k1", Secret: []byte("secret"), Headers: []string{"Host", "Content-Type"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload"))
+	req.Header.Set("Content-Type", "text/plain")
+	_, err := sign(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "host,content-type", req.Header.Get(HeaderSignatureHeaders))
+	rec := serve(srv, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hmac:k1", rec.Body.String())
+
+	t.Run("tampered header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload"))
+		req.Header.Set("Content-Type", "text/plain")
+		_, err := sign(context.Background(), req)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("missing required header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload"))
+		assert.NoError(t, SignHMACRequest(req, "k1", []byte("secret"), time.Now()))
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	srv := newAuthTestServer(NewClientCertMiddleware("admin", "CN=ops,O=Example"))
+
+	withCert := func(subject pkix.Name) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+		return req
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(srv, httptest.NewRequest(http.MethodPost, "/test", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, serve(srv, withCert(pkix.Name{CommonName: "guest"})).Code)
+
+	rec := serve(srv, withCert(pkix.Name{CommonName: "admin"}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "cert:admin", rec.Body.String())
+
+	rec = serve(srv, withCert(pkix.Name{CommonName: "ops", Organization: []string{"Example"}}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestIPAllowListMiddleware(t *testing.T) {
+	_, err := NewIPAllowListMiddleware("10.0.0.0/33")
+	assert.Error(t, err)
+
+	mw, err := NewIPAllowListMiddleware("10.0.0.0/8", "192.168.1.1", "::1")
+	assert.NoError(t, err)
+	srv := newAuthTestServer(mw)
+
+	for addr, status := range map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"192.168.1.1:1234": http.StatusOK,
+		"192.168.1.2:1234": http.StatusForbidden,
+		"[::1]:1234":       http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		req.RemoteAddr = addr
+		assert.Equal(t, status, serve(srv, req).Code, addr)
+	}
+
+	t.Run("ignores forwarded headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		req.RemoteAddr = "1.2.3.4:1234"
+		req.Header.Set(echo.HeaderXForwardedFor, "10.0.0.1")
+		assert.Equal(t, http.StatusForbidden, serve(srv, req).Code)
+	})
+}
+
+func TestServer_RegisterAdminRoutes(t *testing.T) {
+	b := &bytes.Buffer{}
+	log := slog.New(logger.NewSLogHandler(logger.New(logger.ConfigWriter(b)).Spawn(), slog.LevelDebug))
+	srv := NewServer(":0", ServerOptions{AccessLogDisabled: true, AuditLogger: log})
+	srv.RegisterAdminRoutes("/_", NewBearerTokenMiddleware(map[string]string{"ops": "s3cr3t"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/_/admin/shutdown", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	assert.Contains(t, b.String(), "admin: POST /_/admin/shutdown failed")
+	b.Reset()
+
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	assert.Equal(t, http.StatusNoContent, serve(srv, req).Code)
+	assert.Contains(t, b.String(), "admin: POST /_/admin/shutdown")
+	assert.Contains(t, b.String(), "token:ops")
+}
+
+func TestServer_RegisterAdminRoutesAPIKey(t *testing.T) {
+	b := &bytes.Buffer{}
+	log := slog.New(logger.NewSLogHandler(logger.New(logger.ConfigWriter(b)).Spawn(), slog.LevelDebug))
+	store := NewMemoryAPIKeyStore()
+	store.Add(HashAPIKey("k1-secret"), APIKey{ID: "k1", Identity: "ops"})
+	srv := NewServer(":0", ServerOptions{AccessLogDisabled: true, AuditLogger: log})
+	srv.RegisterAdminRoutes("/_", NewAPIKeyMiddleware(APIKeyAuth{Store: store, QueryParam: "api_key"}))
+
+	for _, key := range []string{"k1-secret", "nope"} {
+		b.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/_/admin/shutdown?api_key="+key, nil)
+		serve(srv, req)
+		assert.Contains(t, b.String(), "api_key=xxxxx")
+		assert.NotContains(t, b.String(), key)
+	}
+}
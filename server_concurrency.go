@@ -0,0 +1,367 @@
+package webservice
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errOverloaded = NewError(http.StatusServiceUnavailable, errors.New("server overloaded"))
+
+// ConcurrencyAlgorithm used for adjusting the concurrency limit.
+type ConcurrencyAlgorithm int
+
+const (
+	// ConcurrencyFixed keeps the initial limit.
+	ConcurrencyFixed ConcurrencyAlgorithm = iota
+	// ConcurrencyAIMD increases the limit by one for every limit requests handled within the latency threshold and
+	// decreases it by the backoff ratio for every slow or failed request.
+	ConcurrencyAIMD
+	// ConcurrencyGradient adjusts the limit by the ratio between the long term and the recent latency, increasing it
+	// while latency is stable and decreasing it as soon as requests start to queue.
+	ConcurrencyGradient
+)
+
+// ConcurrencyLimit settings.
+type ConcurrencyLimit struct {
+	// Limit is the initial number of requests handled concurrently. Defaults to 100.
+	Limit int
+	// MinLimit and MaxLimit bound adaptive limits. Default to 1 and 1000.
+	MinLimit int
+	MaxLimit int
+	// Algorithm for adjusting the limit. Defaults to ConcurrencyFixed.
+	Algorithm ConcurrencyAlgorithm
+	// LatencyThreshold above which a request is considered slow by ConcurrencyAIMD. Defaults to 1s.
+	LatencyThreshold time.Duration
+	// Backoff ratio applied to the limit on slow or failed requests by ConcurrencyAIMD. Defaults to 0.9.
+	Backoff float64
+	// MaxWait is the wait budget of requests over the limit. Requests are rejected with 503 Service Unavailable as soon
+	// as the budget is exceeded. Requests over the limit are rejected immediately if 0.
+	MaxWait time.Duration
+	// MaxQueue is the maximum number of waiting requests. When full, the lowest priority request is rejected.
+	// Unlimited if 0.
+	MaxQueue int
+	// Priority of a request. Waiting requests with higher priority are handled first. See PriorityHeader.
+	Priority func(c Context) int
+	// PerRoute keeps a separate limit for each route instead of a single one for all requests.
+	PerRoute bool
+	// Report is called whenever a request completes with the current limit and requests in flight for the route, or
+	// an empty route if not PerRoute. Use it for exporting the limits as metrics, the limits are only available
+	// through it and ConcurrencyLimiter.Stats, not through the MetricsMiddleware.
+	Report func(route string, limit, inflight int)
+}
+
+func (params ConcurrencyLimit) sanitize() ConcurrencyLimit {
+	if params.Limit <= 0 {
+		params.Limit = 100
+	}
+	if params.MinLimit <= 0 {
+		params.MinLimit = 1
+	}
+	if params.MaxLimit <= 0 {
+		params.MaxLimit = 1000
+	}
+	if params.MaxLimit < params.Limit {
+		params.MaxLimit = params.Limit
+	}
+	if params.LatencyThreshold <= 0 {
+		params.LatencyThreshold = time.Second
+	}
+	if params.Backoff <= 0 || params.Backoff >= 1 {
+		params.Backoff = 0.9
+	}
+	return params
+}
+
+// PriorityHeader maps the values of a header to priorities. Unknown values have priority 0.
+func PriorityHeader(header string, classes map[string]int) func(c Context) int {
+	return func(c Context) int {
+		return classes[c.Request().Header.Get(header)]
+	}
+}
+
+// ConcurrencyStats of a limit.
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Waiting  int
+}
+
+// ConcurrencyLimiter limits the number of requests handled concurrently, queueing requests over the limit for up
+// to a wait budget.
+type ConcurrencyLimiter struct {
+	params ConcurrencyLimit
+	global *concurrencyLimit
+	mu     sync.Mutex
+	routes map[string]*concurrencyLimit
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter, use Middleware for adding it to the server or routes.
+func NewConcurrencyLimiter(params ConcurrencyLimit) *ConcurrencyLimiter {
+	params = params.sanitize()
+	limiter := &ConcurrencyLimiter{params: params}
+	if params.PerRoute {
+		limiter.routes = make(map[string]*concurrencyLimit)
+	} else {
+		limiter.global = newConcurrencyLimit(params)
+	}
+	return limiter
+}
+
+// NewConcurrencyLimitMiddleware limits the number of requests handled concurrently, see ConcurrencyLimiter.
+func NewConcurrencyLimitMiddleware(params ConcurrencyLimit) echo.MiddlewareFunc {
+	return NewConcurrencyLimiter(params).Middleware()
+}
+
+// Middleware which applies the limits.
+func (limiter *ConcurrencyLimiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) (err error) {
+			var route string
+			if limiter.params.PerRoute {
+				route = c.Request().Method + " " + c.Path()
+			}
+			limit := limiter.limit(route)
+			var priority int
+			if limiter.params.Priority != nil {
+				priority = limiter.params.Priority(c)
+			}
+
+			if !limit.acquire(c.Request().Context().Done(), priority, limiter.params.MaxWait) {
+				return errOverloaded
+			}
+			start := time.Now()
+			// panics are recovered by an outer middleware, the slot must be released regardless.
+			failed := true
+			defer func() {
+				current, inflight := limit.release(time.Since(start), failed)
+				if limiter.params.Report != nil {
+					limiter.params.Report(route, current, inflight)
+				}
+			}()
+			err = next(c)
+			status := c.Response().Status
+			if err != nil {
+				status = errorStatus(err)
+			}
+			failed = status >= http.StatusInternalServerError
+
+			return err
+		}
+	}
+}
+
+// Stats of the limits, by route if PerRoute, otherwise a single entry with an empty route.
+func (limiter *ConcurrencyLimiter) Stats() map[string]ConcurrencyStats {
+	if limiter.global != nil {
+		return map[string]ConcurrencyStats{"": limiter.global.stats()}
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	stats := make(map[string]ConcurrencyStats, len(limiter.routes))
+	for route, limit := range limiter.routes {
+		stats[route] = limit.stats()
+	}
+	return stats
+}
+
+func (limiter *ConcurrencyLimiter) limit(route string) *concurrencyLimit {
+	if limiter.global != nil {
+		return limiter.global
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limit, ok := limiter.routes[route]
+	if !ok {
+		limit = newConcurrencyLimit(limiter.params)
+		limiter.routes[route] = limit
+	}
+	return limit
+}
+
+// errorStatus returns the status code the error handler will respond with.
+func errorStatus(err error) int {
+	var herr *echo.HTTPError
+	if errors.As(err, &herr) {
+		return herr.Code
+	}
+	var werr Error
+	if errors.As(err, &werr) {
+		return werr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// concurrencyLimit is a single adaptive limit with its queue of waiting requests.
+type concurrencyLimit struct {
+	params   ConcurrencyLimit
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	queue    waitQueue
+	seq      uint64
+	// latency averages used by ConcurrencyGradient
+	longRTT  float64
+	shortRTT float64
+}
+
+func newConcurrencyLimit(params ConcurrencyLimit) *concurrencyLimit {
+	return &concurrencyLimit{params: params, limit: float64(params.Limit)}
+}
+
+func (l *concurrencyLimit) stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConcurrencyStats{Limit: int(l.limit), InFlight: l.inflight, Waiting: len(l.queue)}
+}
+
+// acquire a slot, waiting for up to maxWait. Returns false if the request was rejected.
+func (l *concurrencyLimit) acquire(done <-chan struct{}, priority int, maxWait time.Duration) bool {
+	l.mu.Lock()
+	if l.inflight < int(l.limit) && len(l.queue) == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return true
+	}
+	if maxWait <= 0 {
+		l.mu.Unlock()
+		return false
+	}
+	if l.params.MaxQueue > 0 && len(l.queue) >= l.params.MaxQueue {
+		lowest := l.queue.lowest()
+		if lowest.priority >= priority {
+			l.mu.Unlock()
+			return false
+		}
+		heap.Remove(&l.queue, lowest.index)
+		close(lowest.ready)
+	}
+	l.seq++
+	w := &waiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	heap.Push(&l.queue, w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return w.granted
+	case <-timer.C:
+	case <-done:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		return true
+	}
+	if w.index >= 0 {
+		heap.Remove(&l.queue, w.index)
+	}
+	return false
+}
+
+// release a slot, updating the limit with the request outcome and handing slots over to waiting requests.
+// Returns the current limit and requests in flight.
+func (l *concurrencyLimit) release(latency time.Duration, failed bool) (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.update(latency, failed)
+	for len(l.queue) > 0 && l.inflight < int(l.limit) {
+		w := heap.Pop(&l.queue).(*waiter)
+		w.granted = true
+		l.inflight++
+		close(w.ready)
+	}
+	return int(l.limit), l.inflight
+}
+
+func (l *concurrencyLimit) update(latency time.Duration, failed bool) {
+	switch l.params.Algorithm {
+	case ConcurrencyAIMD:
+		if failed || latency > l.params.LatencyThreshold {
+			l.limit *= l.params.Backoff
+		} else {
+			l.limit += 1 / l.limit
+		}
+	case ConcurrencyGradient:
+		rtt := float64(latency)
+		if l.longRTT == 0 {
+			l.longRTT, l.shortRTT = rtt, rtt
+		}
+		l.shortRTT = l.shortRTT*0.9 + rtt*0.1
+		l.longRTT = l.longRTT*0.995 + rtt*0.005
+		// recover faster from a latency increase once it is over
+		if l.longRTT > 2*l.shortRTT {
+			l.longRTT *= 0.95
+		}
+		gradient := math.Max(0.5, math.Min(1, l.longRTT/l.shortRTT))
+		if failed {
+			gradient = 0.5
+		}
+		target := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit = l.limit*0.8 + target*0.2
+	default:
+		return
+	}
+	l.limit = math.Max(float64(l.params.MinLimit), math.Min(float64(l.params.MaxLimit), l.limit))
+}
+
+// waiter is a request waiting for a slot.
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	granted  bool
+	ready    chan struct{}
+}
+
+// waitQueue is a heap of waiting requests, ordered by priority and then arrival.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// lowest priority waiter, the most recent one on ties.
+func (q waitQueue) lowest() *waiter {
+	lowest := q[0]
+	for _, w := range q[1:] {
+		if w.priority < lowest.priority || (w.priority == lowest.priority && w.seq > lowest.seq) {
+			lowest = w
+		}
+	}
+	return lowest
+}
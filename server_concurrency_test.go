@@ -0,0 +1,170 @@
+package webservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	newServer := func(params ConcurrencyLimit) (*Server, *ConcurrencyLimiter, chan struct{}) {
+		srv := NewServer(":0", ServerOptions{AccessLogDisabled: true})
+		limiter := NewConcurrencyLimiter(params)
+		release := make(chan struct{})
+		srv.Echo.Use(limiter.Middleware())
+		srv.Echo.GET("/block", func(c Context) error {
+			<-release
+			return c.String(http.StatusOK, c.Request().Header.Get("X-Priority"))
+		})
+		srv.Echo.GET("/ok", func(c Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		return srv, limiter, release
+	}
+	serve := func(srv *Server, path, priority string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Priority", priority)
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, req)
+		return rec
+	}
+	waitFor := func(limiter *ConcurrencyLimiter, inflight, waiting int) {
+		require.Eventually(t, func() bool {
+			stats := limiter.Stats()[""]
+			return stats.InFlight == inflight && stats.Waiting == waiting
+		}, time.Second, time.Millisecond)
+	}
+
+	t.Run("reject over limit", func(t *testing.T) {
+		srv, limiter, release := newServer(ConcurrencyLimit{Limit: 1})
+		done := make(chan int)
+		go func() { done <- serve(srv, "/block", "").Code }()
+		waitFor(limiter, 1, 0)
+
+		rec := serve(srv, "/ok", "")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `{"code":503,"message":"server overloaded"}`, rec.Body.String())
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-done)
+		assert.Equal(t, http.StatusOK, serve(srv, "/ok", "").Code)
+		assert.Equal(t, ConcurrencyStats{Limit: 1}, limiter.Stats()[""])
+	})
+
+	t.Run("panics release the slot", func(t *testing.T) {
+		srv, limiter, _ := newServer(ConcurrencyLimit{Limit: 1})
+		srv.Echo.GET("/panic", func(c Context) error {
+			panic("boom")
+		})
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusInternalServerError, serve(srv, "/panic", "").Code)
+		}
+		assert.Equal(t, ConcurrencyStats{Limit: 1}, limiter.Stats()[""])
+		assert.Equal(t, http.StatusOK, serve(srv, "/ok", "").Code)
+	})
+
+	t.Run("wait budget", func(t *testing.T) {
+		srv, limiter, release := newServer(ConcurrencyLimit{Limit: 1, MaxWait: 20 * time.Millisecond})
+		done := make(chan int)
+		go func() { done <- serve(srv, "/block", "").Code }()
+		waitFor(limiter, 1, 0)
+
+		start := time.Now()
+		assert.Equal(t, http.StatusServiceUnavailable, serve(srv, "/ok", "").Code)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		waitFor(limiter, 1, 0)
+
+		go func() { done <- serve(srv, "/ok", "").Code }()
+		waitFor(limiter, 1, 1)
+		close(release)
+		assert.Equal(t, http.StatusOK, <-done)
+		assert.Equal(t, http.StatusOK, <-done)
+	})
+
+	t.Run("priorities", func(t *testing.T) {
+		srv, limiter, release := newServer(ConcurrencyLimit{
+			Limit:    1,
+			MaxWait:  time.Second,
+			MaxQueue: 2,
+			Priority: PriorityHeader("X-Priority", map[string]int{"high": 1, "low": -1}),
+		})
+		first := make(chan string)
+		go func() { first <- serve(srv, "/block", "").Body.String() }()
+		waitFor(limiter, 1, 0)
+
+		var mu sync.Mutex
+		var order []string
+		var wg sync.WaitGroup
+		for i, priority := range []string{"low", "", "high"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := serve(srv, "/block", priority)
+				mu.Lock()
+				order = append(order, priority+":"+http.StatusText(rec.Code))
+				mu.Unlock()
+			}()
+			waitFor(limiter, 1, min(i+1, 2))
+		}
+		close(release)
+		<-first
+		wg.Wait()
+		assert.Equal(t, []string{"low:Service Unavailable", "high:OK", ":OK"}, order, "low priority request shed when the queue is full")
+	})
+
+	t.Run("per route", func(t *testing.T) {
+		var reports []string
+		srv, limiter, release := newServer(ConcurrencyLimit{Limit: 1, PerRoute: true, Report: func(route string, limit, inflight int) {
+			reports = append(reports, route)
+		}})
+		close(release)
+		serve(srv, "/ok", "")
+		serve(srv, "/block", "")
+		assert.Equal(t, []string{"GET /ok", "GET /block"}, reports)
+		assert.Len(t, limiter.Stats(), 2)
+	})
+}
+
+func TestConcurrencyLimit_Adaptive(t *testing.T) {
+	t.Run("aimd", func(t *testing.T) {
+		l := newConcurrencyLimit(ConcurrencyLimit{Limit: 10, Algorithm: ConcurrencyAIMD, LatencyThreshold: 100 * time.Millisecond}.sanitize())
+		for i := 0; i < 10; i++ {
+			l.acquire(nil, 0, 0)
+			l.release(time.Millisecond, false)
+		}
+		assert.Equal(t, 10, l.stats().Limit)
+		for i := 0; i < 20; i++ {
+			l.acquire(nil, 0, 0)
+			l.release(time.Millisecond, false)
+		}
+		assert.Equal(t, 12, l.stats().Limit, "increases by one for every limit requests")
+
+		l.acquire(nil, 0, 0)
+		l.release(time.Second, false)
+		assert.Equal(t, 11, l.stats().Limit, "slow requests decrease the limit")
+		for i := 0; i < 100; i++ {
+			l.acquire(nil, 0, 0)
+			l.release(time.Millisecond, true)
+		}
+		assert.Equal(t, 1, l.stats().Limit, "bound by the minimum limit")
+	})
+
+	t.Run("gradient", func(t *testing.T) {
+		l := newConcurrencyLimit(ConcurrencyLimit{Limit: 20, MaxLimit: 50, Algorithm: ConcurrencyGradient}.sanitize())
+		for i := 0; i < 100; i++ {
+			l.acquire(nil, 0, 0)
+			l.release(10*time.Millisecond, false)
+		}
+		assert.Equal(t, 50, l.stats().Limit, "grows while latency is stable")
+		for i := 0; i < 20; i++ {
+			l.acquire(nil, 0, 0)
+			l.release(100*time.Millisecond, false)
+		}
+		assert.Less(t, l.stats().Limit, 40, "shrinks when latency increases")
+	})
+}
@@ -16,8 +16,9 @@ import (
 )
 
 // RegisterDebugRoutes registers preset handlers for <prefix>/debug/profile/cpu and <prefix>/debug/profile/mem
+// These are registered in the admin listener if one is configured.
 func (srv *Server) RegisterDebugRoutes(prefix string, middlewares ...echo.MiddlewareFunc) {
-	group := srv.Echo.Group(prefix+"/debug/", middlewares...)
+	group := srv.AdminEcho().Group(prefix+"/debug/", middlewares...)
 	group.GET("/profile", srv.handleRedirectToIndex)
 	group.GET("/profile/", srv.handleProfileIndex)
 	group.GET("/profile/cpu", srv.handleCPUProfiler)
@@ -0,0 +1,80 @@
+package webservice
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Options for tuning HTTP/2 connections, both over TLS and cleartext (h2c).
+// Zero values use the golang.org/x/net/http2 defaults.
+type HTTP2Options struct {
+	// MaxConcurrentStreams is the maximum number of concurrent streams per connection. Defaults to 250.
+	MaxConcurrentStreams uint32
+	// MaxReadFrameSize is the largest frame the server is willing to read, between 16KB and 16MB.
+	MaxReadFrameSize uint32
+	// IdleTimeout closes connections with no active streams. Defaults to ServerOptions.IdleTimeout.
+	IdleTimeout time.Duration
+	// ReadIdleTimeout sends a health check ping when no frame is received for this amount of time.
+	ReadIdleTimeout time.Duration
+	// PingTimeout closes the connection if a health check ping is not answered within this time. Defaults to 15s.
+	PingTimeout time.Duration
+	// WriteByteTimeout closes the connection if no data can be written for this amount of time.
+	WriteByteTimeout time.Duration
+	// MaxUploadBufferPerConnection is the initial flow control window size for each connection.
+	MaxUploadBufferPerConnection int32
+	// MaxUploadBufferPerStream is the initial flow control window size for each stream.
+	MaxUploadBufferPerStream int32
+}
+
+// server creates the HTTP/2 server, using the idle timeout of the HTTP/1 server if not set. The default is applied
+// explicitly since h2c connections do not inherit it from the HTTP/1 server.
+func (opts HTTP2Options) server(idleTimeout time.Duration) *http2.Server {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = idleTimeout
+	}
+	return &http2.Server{
+		MaxConcurrentStreams:         opts.MaxConcurrentStreams,
+		MaxReadFrameSize:             opts.MaxReadFrameSize,
+		IdleTimeout:                  opts.IdleTimeout,
+		ReadIdleTimeout:              opts.ReadIdleTimeout,
+		PingTimeout:                  opts.PingTimeout,
+		WriteByteTimeout:             opts.WriteByteTimeout,
+		MaxUploadBufferPerConnection: opts.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     opts.MaxUploadBufferPerStream,
+	}
+}
+
+// configureHTTP2 prepares the HTTP/2 settings and, if enabled, serves h2c on the cleartext server.
+func (srv *Server) configureHTTP2(opts ServerOptions) {
+	if opts.HTTP2 != (HTTP2Options{}) {
+		srv.http2 = opts.HTTP2.server(opts.IdleTimeout)
+	}
+	if opts.H2C {
+		// echo overrides the server handler when starting so h2c servers are started directly, see Server.start.
+		srv.h2c = true
+		// the h2c connections are hijacked from the server, configuring it sends them a GOAWAY on shutdown.
+		// A separate HTTP/2 server is used since it keeps track of the connections of a single server.
+		h2s := opts.HTTP2.server(opts.IdleTimeout)
+		if err := http2.ConfigureServer(srv.Echo.Server, h2s); err != nil {
+			srv.log.Errorf("webserver: failed to configure h2c: %+v", err)
+		}
+		// only the shutdown hook is needed, a TLS config would make echo serve TLS.
+		srv.Echo.Server.TLSConfig = nil
+		srv.Echo.Server.Handler = h2c.NewHandler(srv.Echo, h2s)
+		srv.Echo.Server.ErrorLog = srv.Echo.StdLogger
+	}
+}
+
+// configureTLSHTTP2 applies the HTTP/2 settings to the TLS server. Must be called after setting the TLS config.
+func (srv *Server) configureTLSHTTP2() error {
+	if srv.http2 == nil {
+		return nil
+	}
+	if err := http2.ConfigureServer(srv.Echo.TLSServer, srv.http2); err != nil {
+		return fmt.Errorf("failed to configure HTTP/2; %w", err)
+	}
+	return nil
+}
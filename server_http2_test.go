@@ -0,0 +1,131 @@
+package webservice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestServer_H2C(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(l.Addr().String(), ServerOptions{
+		Listener:          l,
+		H2C:               true,
+		HTTP2:             HTTP2Options{MaxConcurrentStreams: 10},
+		AccessLogDisabled: true,
+	})
+	srv.Echo.GET("/", func(c Context) error {
+		return c.String(http.StatusOK, c.Request().Proto)
+	})
+	startTestServer(t, srv)
+
+	cli := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	res, err := cli.Get("http://" + l.Addr().String() + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+
+	res, err = http.Get("http://" + l.Addr().String() + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 1, res.ProtoMajor, "HTTP/1 is still supported")
+}
+
+func TestServer_H2CShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(l.Addr().String(), ServerOptions{Listener: l, H2C: true, AccessLogDisabled: true})
+	started, release := make(chan struct{}), make(chan struct{})
+	srv.Echo.GET("/", func(c Context) error {
+		close(started)
+		<-release
+		return c.String(http.StatusOK, "done")
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", l.Addr().String())
+		return err == nil
+	}, time.Second, time.Millisecond)
+	cc, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(conn)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	type result struct {
+		res *http.Response
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+		res, err := cc.RoundTrip(req)
+		results <- result{res, err}
+	}()
+	<-started
+
+	require.NoError(t, srv.Stop())
+	require.NoError(t, <-done)
+	require.Eventually(t, func() bool { return !cc.CanTakeNewRequest() }, time.Second, time.Millisecond, "GOAWAY received")
+
+	close(release)
+	r := <-results
+	require.NoError(t, r.err, "active stream is drained")
+	body, err := io.ReadAll(r.res.Body)
+	require.NoError(t, err)
+	r.res.Body.Close()
+	assert.Equal(t, "done", string(body))
+	assert.Eventually(t, func() bool { return cc.State().Closed }, time.Second, time.Millisecond)
+}
+
+func TestHTTP2Options(t *testing.T) {
+	assert.Equal(t, time.Minute, HTTP2Options{}.server(time.Minute).IdleTimeout, "defaults to the server idle timeout")
+	assert.Equal(t, time.Second, HTTP2Options{IdleTimeout: time.Second}.server(time.Minute).IdleTimeout)
+}
+
+func TestServer_HTTP2TLS(t *testing.T) {
+	var ca = newTestCert(t, nil, 1, x509.Certificate{Subject: pkix.Name{CommonName: "ca"}})
+	var cert = newTestCert(t, &ca, 10, x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(l.Addr().String(), ServerOptions{
+		Listener:          l,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{pair}},
+		HTTP2:             HTTP2Options{MaxConcurrentStreams: 10, MaxReadFrameSize: 1 << 20},
+		AccessLogDisabled: true,
+	})
+	srv.Echo.GET("/", func(c Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	startTestServer(t, srv)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cli := &http.Client{Transport: &http2.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	res, err := cli.Get("https://" + l.Addr().String() + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+}
@@ -0,0 +1,245 @@
+package webservice
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const contextKeyJWTClaims = "webservice.jwt_claims"
+
+type contextKeyClaims struct{}
+
+// JWTClaims of a validated token.
+type JWTClaims map[string]any
+
+// GetJWTClaims returns the claims set by the JWT middleware.
+func GetJWTClaims(c Context) (JWTClaims, bool) {
+	claims, ok := c.Get(contextKeyJWTClaims).(JWTClaims)
+	return claims, ok
+}
+
+// JWTClaimsFromContext returns the claims set by the JWT middleware in the request context.
+func JWTClaimsFromContext(ctx context.Context) (JWTClaims, bool) {
+	claims, ok := ctx.Value(contextKeyClaims{}).(JWTClaims)
+	return claims, ok
+}
+
+// String returns a string claim.
+func (claims JWTClaims) String(name string) (string, bool) {
+	value, ok := claims[name].(string)
+	return value, ok
+}
+
+// Strings returns a claim which is either a string or an array of strings.
+func (claims JWTClaims) Strings(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Int64 returns a numeric claim.
+func (claims JWTClaims) Int64(name string) (int64, bool) {
+	value, ok := claims[name].(float64)
+	if !ok || value != math.Trunc(value) {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+// Bool returns a boolean claim.
+func (claims JWTClaims) Bool(name string) (bool, bool) {
+	value, ok := claims[name].(bool)
+	return value, ok
+}
+
+// Time returns a NumericDate claim, e.g. "exp".
+func (claims JWTClaims) Time(name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(value)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+// Subject returns the "sub" claim.
+func (claims JWTClaims) Subject() string {
+	value, _ := claims.String("sub")
+	return value
+}
+
+// Issuer returns the "iss" claim.
+func (claims JWTClaims) Issuer() string {
+	value, _ := claims.String("iss")
+	return value
+}
+
+// Audience returns the "aud" claim.
+func (claims JWTClaims) Audience() []string {
+	return claims.Strings("aud")
+}
+
+// ExpiresAt returns the "exp" claim.
+func (claims JWTClaims) ExpiresAt() time.Time {
+	value, _ := claims.Time("exp")
+	return value
+}
+
+// Scopes returns the space separated "scope" claim or the "scp" array claim.
+func (claims JWTClaims) Scopes() []string {
+	if scope, ok := claims.String("scope"); ok {
+		return strings.Fields(scope)
+	}
+	return claims.Strings("scp")
+}
+
+// JWTAuth settings.
+type JWTAuth struct {
+	// Keys verifying the tokens, e.g. a JWKS loaded with LoadJWKSFile or a RemoteJWKS.
+	Keys JWTKeySet
+	// Algorithms accepted. Defaults to RS256, ES256 and EdDSA. HS256 must be enabled explicitly.
+	Algorithms []string
+	// Issuer of the tokens, checked if not empty.
+	Issuer string
+	// Audience which must be included in the tokens, checked if not empty.
+	Audience string
+	// ClockSkew tolerated when checking the exp, nbf and iat claims. Defaults to 1 minute.
+	ClockSkew time.Duration
+	// RequiredScopes which must all be granted by the token, otherwise the request is forbidden.
+	RequiredScopes []string
+	// Authorize is called with the claims of valid tokens, requests are forbidden if it returns false.
+	Authorize func(c Context, claims JWTClaims) bool
+	// Skipper defines a function to skip the middleware.
+	Skipper func(c Context) bool
+}
+
+// NewJWTMiddleware only accepts requests with a valid JWT bearer token. Requests with a missing or invalid token are
+// rejected with 401 and requests failing the scopes or authorization checks are rejected with 403. The token claims
+// are available through GetJWTClaims, or JWTClaimsFromContext with the request context, and the token subject is set
+// as the request Identity.
+func NewJWTMiddleware(params JWTAuth) echo.MiddlewareFunc {
+	if params.Algorithms == nil {
+		params.Algorithms = []string{JWTAlgRS256, JWTAlgES256, JWTAlgEdDSA}
+	}
+	if params.ClockSkew <= 0 {
+		params.ClockSkew = time.Minute
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			if params.Skipper != nil && params.Skipper(c) {
+				return next(c)
+			}
+			req := c.Request()
+			token, ok := bearerToken(req)
+			if !ok {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return errUnauthorized
+			}
+			claims, err := params.validate(req.Context(), token, time.Now())
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return NewError(errUnauthorized.Code, err)
+			}
+
+			scopes := claims.Scopes()
+			for _, scope := range params.RequiredScopes {
+				if !slices.Contains(scopes, scope) {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="insufficient_scope"`)
+					return errForbidden
+				}
+			}
+			if params.Authorize != nil && !params.Authorize(c, claims) {
+				return errForbidden
+			}
+
+			c.Set(contextKeyJWTClaims, claims)
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), contextKeyClaims{}, claims)))
+			setIdentity(c, "jwt:"+claims.Subject())
+			return next(c)
+		}
+	}
+}
+
+// validate the signature and claims of a token. The errors are safe to return to the caller.
+func (params JWTAuth) validate(ctx context.Context, token string, now time.Time) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	if !slices.Contains(params.Algorithms, header.Alg) || jwtAlgorithms[header.Alg] == "" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	key, err := params.Keys.JWTKey(ctx, header.Kid)
+	if err != nil {
+		return nil, errors.New("unknown token key")
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Alg {
+		return nil, errors.New("token algorithm does not match the key")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if err := verifyMessage(jwtAlgorithms[header.Alg], key.Key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return nil, errors.New("token without expiry")
+	}
+	if now.After(exp.Add(params.ClockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(params.ClockSkew).Before(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+	if iat, ok := claims.Time("iat"); ok && now.Add(params.ClockSkew).Before(iat) {
+		return nil, errors.New("token issued in the future")
+	}
+	if params.Issuer != "" && claims.Issuer() != params.Issuer {
+		return nil, errors.New("invalid token issuer")
+	}
+	if params.Audience != "" && !slices.Contains(claims.Audience(), params.Audience) {
+		return nil, errors.New("invalid token audience")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,214 @@
+package webservice
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// AddressUnixPrefix is the prefix for server addresses which are unix domain socket paths, e.g. "unix:/run/app.sock".
+	AddressUnixPrefix = "unix:"
+	// AddressSystemdPrefix is the prefix for server addresses which are sockets passed by systemd socket activation.
+	// Use "systemd:" for the first socket or "systemd:<name>" for the socket with the matching FileDescriptorName.
+	AddressSystemdPrefix = "systemd:"
+
+	// systemdListenFDsStart is the first file descriptor passed by systemd, SD_LISTEN_FDS_START.
+	systemdListenFDsStart = 3
+)
+
+// UnixSocketOptions are used when creating unix domain socket listeners.
+type UnixSocketOptions struct {
+	// Mode of the socket file, e.g. 0660. The umask is used if not set. The socket is only moved into place once its
+	// mode and ownership are set, so the directory of the socket must be writable.
+	Mode os.FileMode
+	// Owner of the socket file, either a user name or a numeric ID.
+	Owner string
+	// Group of the socket file, either a group name or a numeric ID.
+	Group string
+}
+
+// listen creates a listener for the address which can be a TCP address, a unix socket or a systemd socket.
+func listen(address string, opts UnixSocketOptions) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, AddressUnixPrefix):
+		return listenUnix(strings.TrimPrefix(address, AddressUnixPrefix), opts)
+	case strings.HasPrefix(address, AddressSystemdPrefix):
+		return systemdListener(strings.TrimPrefix(address, AddressSystemdPrefix))
+	default:
+		return net.Listen("tcp", address)
+	}
+}
+
+func listenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	// remove stale sockets left behind by a previous process which did not exit cleanly, but never live ones.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket %s; %w", path, err)
+			}
+		}
+	}
+
+	if opts == (UnixSocketOptions{}) {
+		return net.Listen("unix", path)
+	}
+
+	// the socket is prepared in a private directory and only then moved into place, so it is never accessible with
+	// the default mode or ownership, not even briefly.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".s")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory; %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	if opts.Mode != 0 {
+		if err := os.Chmod(tmp, opts.Mode); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to change socket mode; %w", err)
+		}
+	}
+	if opts.Owner != "" || opts.Group != "" {
+		uid, gid, err := lookupOwner(opts.Owner, opts.Group)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if err := os.Chown(tmp, uid, gid); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to change socket ownership; %w", err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to move socket into place; %w", err)
+	}
+	ul := &unixListener{UnixListener: l, path: path}
+	ul.unlink.Store(true)
+	return ul, nil
+}
+
+// unixListener is a socket moved into place after listening. It reports and removes the socket file on close, which
+// net.UnixListener only does for the path it listened on.
+type unixListener struct {
+	*net.UnixListener
+	path   string
+	unlink atomic.Bool
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// SetUnlinkOnClose sets whether the socket file is removed when the listener is closed, see net.UnixListener.
+func (l *unixListener) SetUnlinkOnClose(unlink bool) {
+	l.unlink.Store(unlink)
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if err == nil && l.unlink.Load() {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+// lookupOwner resolves user and group names or IDs. Returns -1 for the ones not set so os.Chown does not change them.
+func lookupOwner(owner, group string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			usr, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown socket owner %q; %w", owner, err)
+			}
+			if uid, err = strconv.Atoi(usr.Uid); err != nil {
+				return 0, 0, fmt.Errorf("unsupported user ID %q; %w", usr.Uid, err)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			grp, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown socket group %q; %w", group, err)
+			}
+			if gid, err = strconv.Atoi(grp.Gid); err != nil {
+				return 0, 0, fmt.Errorf("unsupported group ID %q; %w", grp.Gid, err)
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+var systemd struct {
+	once      sync.Once
+	listeners []net.Listener
+	names     []string
+	err       error
+}
+
+// systemdListener returns the listener passed by systemd with the given name or the first one if name is empty.
+// Each listener can only be used once.
+func systemdListener(name string) (net.Listener, error) {
+	systemd.once.Do(func() {
+		systemd.listeners, systemd.names, systemd.err = systemdListeners()
+	})
+	if systemd.err != nil {
+		return nil, systemd.err
+	}
+	for i := range systemd.listeners {
+		if systemd.listeners[i] == nil || (name != "" && systemd.names[i] != name) {
+			continue
+		}
+		l := systemd.listeners[i]
+		systemd.listeners[i] = nil
+		return l, nil
+	}
+	return nil, fmt.Errorf("no systemd socket available [name:%s]", name)
+}
+
+// systemdListeners reads the sockets passed by systemd socket activation using the LISTEN_PID, LISTEN_FDS and
+// LISTEN_FDNAMES environment variables.
+func systemdListeners() ([]net.Listener, []string, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	var names = strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for len(names) < count {
+		names = append(names, "")
+	}
+
+	var listeners = make([]net.Listener, count)
+	for i := 0; i < count; i++ {
+		f := os.NewFile(uintptr(systemdListenFDsStart+i), names[i])
+		listeners[i], err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid systemd socket [fd:%d]; %w", systemdListenFDsStart+i, err)
+		}
+	}
+	return listeners, names[:count], nil
+}
@@ -20,6 +20,8 @@ type AccessLogger struct {
 	Logger *slog.Logger
 	// Discarder func can be used to ignore specific requests from being logged.
 	Discarder func(c Context) bool
+	// RedactQueryParams are the query parameters whose values are replaced in the logged URIs, e.g. "api_key".
+	RedactQueryParams []string
 }
 
 func NewAccessLogMiddleware(params AccessLogger) echo.MiddlewareFunc {
@@ -32,6 +34,7 @@ func NewAccessLogMiddleware(params AccessLogger) echo.MiddlewareFunc {
 	out := accessLogger{
 		discard: params.Discarder,
 		alog:    logger.NewSLogWrapper(params.Logger),
+		redact:  params.RedactQueryParams,
 	}
 	return out.Middleware
 }
@@ -41,6 +44,7 @@ func NewAccessLogMiddleware(params AccessLogger) echo.MiddlewareFunc {
 type accessLogger struct {
 	alog    logger.SLogger
 	discard func(c Context) bool
+	redact  []string
 }
 
 func (logger accessLogger) getRequestID(c Context) string {
@@ -90,12 +94,13 @@ func (logger accessLogger) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 
 		req := c.Request()
 		res := c.Response()
+		uri := loggedURI(c, logger.redact)
 
 		l := logger.alog.With(
 			slog.String("id", logger.getRequestID(c)),
 			slog.String("path", logger.sanitizePath(req.URL.Path)),
 			slog.String("method", req.Method),
-			slog.String("uri", req.RequestURI),
+			slog.String("uri", uri),
 			slog.Int64("bytes_in", logger.parseContentLength(req.Header.Get(echo.HeaderContentLength))),
 			slog.Int64("bytes_out", res.Size),
 			slog.Any("remote_ip", strings.Split(c.RealIP(), ",")),
@@ -107,18 +112,27 @@ func (logger accessLogger) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 			slog.Duration("latency_ns", elapsed),
 			slog.Any("tags", req.Header.Values(textproto.CanonicalMIMEHeaderKey("X-Tags"))),
 		)
+		if timeout, _ := c.Get(contextKeyTimeout).(bool); timeout {
+			l = l.With(slog.Bool("timeout", true))
+		}
+		if id := Identity(c); id != "" {
+			l = l.With(slog.String("identity", id))
+		}
+		if key, ok := GetAPIKey(c); ok {
+			l = l.With(slog.String("key_id", key.ID))
+		}
 
 		if err != nil {
-			l.Errorf("%s %s: %+v", req.Method, req.RequestURI, err)
+			l.Errorf("%s %s: %+v", req.Method, uri, err)
 			return
 		}
 
 		if res.Status >= 500 && res.Status < 600 {
-			l.Errorf("%s %s", req.Method, req.RequestURI)
+			l.Errorf("%s %s", req.Method, uri)
 			return
 		}
 
-		l.Infof("%s %s", req.Method, req.RequestURI)
+		l.Infof("%s %s", req.Method, uri)
 
 		return err
 	}
@@ -1,6 +1,10 @@
 package webservice
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -31,3 +35,88 @@ func NewMetricsMiddleware(register func(method, route, status string, elapsed ti
 		}
 	}
 }
+
+const contextKeyTimeout = "webservice.timeout"
+
+// Timeout settings.
+type Timeout struct {
+	// Timeout is the maximum duration for handling a request.
+	Timeout time.Duration
+	// Status code of the response when the timeout is exceeded. Defaults to 503 Service Unavailable.
+	Status int
+}
+
+// NewTimeoutMiddleware sets a deadline on the request context.
+// Handlers must respect the context for the timeout to be enforced. If the deadline is exceeded and no response was
+// sent then an error with the configured status is returned. The access log of timed out requests has timeout=true.
+// Requests are passed through unchanged if the timeout is not positive.
+func NewTimeoutMiddleware(params Timeout) echo.MiddlewareFunc {
+	if params.Status == 0 {
+		params.Status = http.StatusServiceUnavailable
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if params.Timeout <= 0 {
+			return next
+		}
+		return func(c Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), params.Timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return err
+			}
+			c.Set(contextKeyTimeout, true)
+			if c.Response().Committed {
+				return err
+			}
+			return NewError(params.Status, fmt.Errorf("request timed out after %s", params.Timeout))
+		}
+	}
+}
+
+// AddWithTimeout registers a route with a handler deadline. See NewTimeoutMiddleware.
+func (srv *Server) AddWithTimeout(method, path string, timeout time.Duration, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) *echo.Route {
+	return srv.Echo.Add(method, path, handler, append([]echo.MiddlewareFunc{NewTimeoutMiddleware(Timeout{Timeout: timeout})}, middlewares...)...)
+}
+
+// HeaderRequestTimeout contains the remaining time budget of the caller for a request, in the gRPC timeout format,
+// e.g. "250m" for 250 milliseconds. See ClientOptions.PropagateDeadline.
+const HeaderRequestTimeout = "X-Request-Timeout"
+
+// Deadline settings.
+type Deadline struct {
+	// Header with the caller's time budget. Defaults to HeaderRequestTimeout.
+	Header string
+	// MaxTimeout caps the time budget requested by callers. No cap is applied if 0.
+	MaxTimeout time.Duration
+}
+
+// NewDeadlineMiddleware sets the request context deadline from the caller's time budget header.
+// Handlers passing the request context on to a Client will shrink the timeout of their requests accordingly.
+func NewDeadlineMiddleware(params Deadline) echo.MiddlewareFunc {
+	if params.Header == "" {
+		params.Header = HeaderRequestTimeout
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			value := c.Request().Header.Get(params.Header)
+			if value == "" {
+				return next(c)
+			}
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return NewError(http.StatusBadRequest, err)
+			}
+			if params.MaxTimeout > 0 && timeout > params.MaxTimeout {
+				timeout = params.MaxTimeout
+			}
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			return next(c)
+		}
+	}
+}
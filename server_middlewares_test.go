@@ -0,0 +1,95 @@
+package webservice
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	b := &bytes.Buffer{}
+	log := slog.New(logger.NewSLogHandler(logger.New(logger.ConfigWriter(b)).Spawn(), slog.LevelDebug))
+	srv := NewServer(":0", ServerOptions{Logger: log})
+
+	srv.AddWithTimeout(http.MethodGet, "/slow", 10*time.Millisecond, func(c Context) error {
+		<-c.Request().Context().Done()
+		return c.Request().Context().Err()
+	})
+	srv.AddWithTimeout(http.MethodGet, "/fast", time.Second, func(c Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	srv.Echo.GET("/gateway", func(c Context) error {
+		<-c.Request().Context().Done()
+		return nil
+	}, NewTimeoutMiddleware(Timeout{Timeout: 10 * time.Millisecond, Status: http.StatusGatewayTimeout}))
+	srv.AddWithTimeout(http.MethodGet, "/unlimited", 0, func(c Context) error {
+		_, ok := c.Request().Context().Deadline()
+		assert.False(t, ok)
+		return c.NoContent(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"code":503,"message":"request timed out after 10ms"}`, rec.Body.String())
+	assert.Contains(t, b.String(), `"timeout":true`)
+	b.Reset()
+
+	rec = httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, b.String(), `"timeout"`)
+
+	rec = httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gateway", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unlimited", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	srv := NewServer(":0", ServerOptions{PropagateDeadline: true, AccessLogDisabled: true})
+	srv.Echo.GET("/", func(c Context) error {
+		deadline, ok := c.Request().Context().Deadline()
+		if !ok {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.String(http.StatusOK, time.Until(deadline).Round(time.Second).String())
+	})
+	srv.Echo.GET("/capped", func(c Context) error {
+		deadline, _ := c.Request().Context().Deadline()
+		return c.String(http.StatusOK, time.Until(deadline).Round(time.Second).String())
+	}, NewDeadlineMiddleware(Deadline{Header: "Grpc-Timeout", MaxTimeout: 2 * time.Second}))
+
+	serve := func(path, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/", "", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve("/", HeaderRequestTimeout, "5S")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "5s", rec.Body.String())
+
+	rec = serve("/", HeaderRequestTimeout, "5s")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("/capped", "Grpc-Timeout", "1M")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2s", rec.Body.String())
+}
@@ -0,0 +1,344 @@
+package webservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/maphash"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Rate limiting response headers.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+var errRateLimited = NewError(http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+
+// Rate of requests allowed in a period.
+type Rate struct {
+	// Limit is the number of requests allowed in each Period.
+	Limit int
+	// Period defaults to 1 second.
+	Period time.Duration
+	// Burst is the maximum number of requests allowed at once by token buckets. Defaults to Limit.
+	Burst int
+}
+
+func (rate Rate) sanitize() Rate {
+	if rate.Limit <= 0 {
+		rate.Limit = 1
+	}
+	if rate.Period <= 0 {
+		rate.Period = time.Second
+	}
+	if rate.Burst <= 0 {
+		rate.Burst = rate.Limit
+	}
+	return rate
+}
+
+// interval between tokens being added to a token bucket.
+func (rate Rate) interval() time.Duration {
+	return rate.Period / time.Duration(rate.Limit)
+}
+
+// RateLimitResult is the state of the rate limit of a key after a request.
+type RateLimitResult struct {
+	// Allowed is true if the request is within the limit.
+	Allowed bool
+	// Limit is the maximum number of requests allowed.
+	Limit int
+	// Remaining number of requests allowed right now.
+	Remaining int
+	// Reset is the time until the limit is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, if not allowed.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the rate limiting state of each key.
+// Implement this for sharing the limits between instances, e.g. using a shared cache.
+type RateLimitStore interface {
+	// Allow accounts for a request with the key and reports if it is allowed.
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// RateLimit settings.
+type RateLimit struct {
+	// Store keeps the rate limiting state. Defaults to an in-memory token bucket store using Rate.
+	Store RateLimitStore
+	// Rate used by the default store.
+	Rate Rate
+	// Key identifies the client being limited. Defaults to RateLimitByIP.
+	// Requests for which the key is empty are limited by IP.
+	Key func(c Context) string
+	// Skipper skips rate limiting for requests for which it returns true.
+	Skipper func(c Context) bool
+	// FailOpen allows requests when the store fails. By default the store error is returned.
+	FailOpen bool
+}
+
+// NewRateLimitMiddleware rejects requests exceeding the rate limit with 429 Too Many Requests.
+// All responses include the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers and rejected ones also
+// include Retry-After.
+func NewRateLimitMiddleware(params RateLimit) echo.MiddlewareFunc {
+	if params.Store == nil {
+		params.Store = NewTokenBucketStore(params.Rate)
+	}
+	if params.Key == nil {
+		params.Key = RateLimitByIP
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			if params.Skipper != nil && params.Skipper(c) {
+				return next(c)
+			}
+			key := params.Key(c)
+			if key == "" {
+				key = RateLimitByIP(c)
+			}
+			res, err := params.Store.Allow(c.Request().Context(), key)
+			if err != nil {
+				if params.FailOpen {
+					return next(c)
+				}
+				return err
+			}
+
+			header := c.Response().Header()
+			header.Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+			header.Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+			header.Set(HeaderRateLimitReset, seconds(res.Reset))
+			if !res.Allowed {
+				header.Set(HeaderRetryAfter, seconds(res.RetryAfter))
+				return errRateLimited
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// seconds rounded up, as used in the rate limiting headers.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// RateLimitByIP keys requests by the client IP. Forwarding headers are ignored unless echo.Echo.IPExtractor is
+// configured, see it for running behind proxies.
+func RateLimitByIP(c Context) string {
+	return "ip:" + clientIP(c)
+}
+
+// RateLimitByRoute keys requests by method and route, limiting all clients together.
+func RateLimitByRoute(c Context) string {
+	return "route:" + c.Request().Method + " " + c.Path()
+}
+
+// RateLimitByHeader keys requests by the value of a header.
+func RateLimitByHeader(name string) func(c Context) string {
+	return func(c Context) string {
+		if value := c.Request().Header.Get(name); value != "" {
+			return "header:" + value
+		}
+		return ""
+	}
+}
+
+// RateLimitByAPIKey keys requests by the API key in a header. Keys are hashed so they are never kept by the stores.
+func RateLimitByAPIKey(header string) func(c Context) string {
+	return func(c Context) string {
+		value := c.Request().Header.Get(header)
+		if value == "" {
+			return ""
+		}
+		hash := sha256.Sum256([]byte(value))
+		return "key:" + hex.EncodeToString(hash[:16])
+	}
+}
+
+// tokenBucket holds up to Rate.Burst tokens, refilled at Rate.Limit tokens per Rate.Period.
+// The zero value is a full bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill the bucket up to now.
+func (b *tokenBucket) refill(rate Rate, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(rate.Burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(rate.Burst), b.tokens+float64(elapsed)/float64(rate.interval()))
+	}
+	b.last = now
+}
+
+// take a token from the bucket, if available.
+func (b *tokenBucket) take(rate Rate, now time.Time) RateLimitResult {
+	b.refill(rate, now)
+	res := RateLimitResult{Limit: rate.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(rate.interval()))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(rate.Burst) - b.tokens) * float64(rate.interval()))
+
+	return res
+}
+
+func (b *tokenBucket) expired(rate Rate, now time.Time) bool {
+	return now.Sub(b.last) >= time.Duration(rate.Burst)*rate.interval()
+}
+
+// slidingWindow approximates a sliding window by weighting the count of the previous fixed window by how much of it
+// still overlaps the sliding window. The zero value has no requests.
+type slidingWindow struct {
+	start    time.Time
+	previous int
+	current  int
+}
+
+func (w *slidingWindow) take(rate Rate, now time.Time) RateLimitResult {
+	if end := w.start.Add(rate.Period); !now.Before(end) {
+		w.previous = 0
+		if now.Before(end.Add(rate.Period)) {
+			w.previous = w.current
+		}
+		w.current = 0
+		w.start = now.Truncate(rate.Period)
+	}
+
+	elapsed := now.Sub(w.start)
+	weight := 1 - float64(elapsed)/float64(rate.Period)
+	count := float64(w.previous)*weight + float64(w.current)
+	res := RateLimitResult{Limit: rate.Limit, Reset: rate.Period - elapsed}
+	if count+1 <= float64(rate.Limit) {
+		w.current++
+		res.Allowed = true
+		res.Remaining = int(float64(rate.Limit) - count - 1)
+		return res
+	}
+	if w.current+1 > rate.Limit {
+		res.RetryAfter = rate.Period - elapsed
+	} else {
+		// wait for the previous window to weigh little enough
+		needed := 1 - float64(rate.Limit-w.current-1)/float64(w.previous)
+		res.RetryAfter = time.Duration(needed*float64(rate.Period)) - elapsed
+	}
+
+	return res
+}
+
+func (w *slidingWindow) expired(rate Rate, now time.Time) bool {
+	return now.Sub(w.start) >= 2*rate.Period
+}
+
+// rateLimitState is implemented by the in-memory rate limiting algorithms.
+type rateLimitState interface {
+	take(rate Rate, now time.Time) RateLimitResult
+	expired(rate Rate, now time.Time) bool
+}
+
+const rateLimitShards = 32
+
+type rateLimitShard struct {
+	mu    sync.Mutex
+	items map[string]rateLimitState
+}
+
+// MemoryRateLimitStore keeps the rate limiting state in memory, split across shards with separate locks.
+// Keys which are back to their initial state are removed from all shards once every Rate.Period.
+type MemoryRateLimitStore struct {
+	rate   Rate
+	state  func() rateLimitState
+	seed   maphash.Seed
+	shards [rateLimitShards]rateLimitShard
+	swept  atomic.Int64
+	now    func() time.Time
+}
+
+// NewTokenBucketStore creates an in-memory store using a token bucket per key, allowing bursts of up to Rate.Burst
+// requests while limiting the sustained rate to Rate.Limit requests per Rate.Period.
+func NewTokenBucketStore(rate Rate) *MemoryRateLimitStore {
+	return newMemoryRateLimitStore(rate, func() rateLimitState { return &tokenBucket{} })
+}
+
+// NewSlidingWindowStore creates an in-memory store using a sliding window per key, allowing Rate.Limit requests in
+// any Rate.Period.
+func NewSlidingWindowStore(rate Rate) *MemoryRateLimitStore {
+	return newMemoryRateLimitStore(rate, func() rateLimitState { return &slidingWindow{} })
+}
+
+func newMemoryRateLimitStore(rate Rate, state func() rateLimitState) *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		rate:  rate.sanitize(),
+		state: state,
+		seed:  maphash.MakeSeed(),
+		now:   time.Now,
+	}
+	for i := range store.shards {
+		store.shards[i].items = make(map[string]rateLimitState)
+	}
+	return store
+}
+
+// Allow accounts for a request with the key and reports if it is allowed.
+func (store *MemoryRateLimitStore) Allow(_ context.Context, key string) (RateLimitResult, error) {
+	now := store.now()
+	if swept := store.swept.Load(); now.UnixNano()-swept >= int64(store.rate.Period) && store.swept.CompareAndSwap(swept, now.UnixNano()) {
+		store.sweep(now)
+	}
+
+	shard := &store.shards[maphash.String(store.seed, key)%rateLimitShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	state, ok := shard.items[key]
+	if !ok {
+		state = store.state()
+		shard.items[key] = state
+	}
+
+	return state.take(store.rate, now), nil
+}
+
+// sweep removes the expired keys of all shards.
+func (store *MemoryRateLimitStore) sweep(now time.Time) {
+	for i := range store.shards {
+		shard := &store.shards[i]
+		shard.mu.Lock()
+		for k, state := range shard.items {
+			if state.expired(store.rate, now) {
+				delete(shard.items, k)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// Len returns the number of keys in the store.
+func (store *MemoryRateLimitStore) Len() int {
+	var n int
+	for i := range store.shards {
+		store.shards[i].mu.Lock()
+		n += len(store.shards[i].items)
+		store.shards[i].mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,152 @@
+package webservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Add(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func TestTokenBucketStore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := NewTokenBucketStore(Rate{Limit: 10, Period: time.Second, Burst: 2})
+	store.now = clock.Now
+
+	res, _ := store.Allow(context.Background(), "a")
+	assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 100 * time.Millisecond}, res)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 200*time.Millisecond, res.Reset)
+
+	res, _ = store.Allow(context.Background(), "b")
+	assert.True(t, res.Allowed, "keys are limited separately")
+
+	clock.Add(100 * time.Millisecond)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.True(t, res.Allowed, "token refilled")
+	res, _ = store.Allow(context.Background(), "a")
+	assert.False(t, res.Allowed)
+
+	assert.Equal(t, 2, store.Len())
+	store.sweep(clock.Now())
+	assert.Equal(t, 2, store.Len(), "buckets in use are kept")
+	clock.Add(time.Second)
+	store.sweep(clock.Now())
+	assert.Equal(t, 0, store.Len(), "full buckets are removed")
+
+	store.Allow(context.Background(), "a")
+	clock.Add(time.Second)
+	for i := 0; i < 100; i++ {
+		store.Allow(context.Background(), "c"+strconv.Itoa(i))
+	}
+	assert.Equal(t, 100, store.Len(), "all shards are swept periodically")
+}
+
+func TestSlidingWindowStore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := NewSlidingWindowStore(Rate{Limit: 4, Period: time.Second})
+	store.now = clock.Now
+
+	for i := 0; i < 4; i++ {
+		res, _ := store.Allow(context.Background(), "a")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3-i, res.Remaining)
+	}
+	res, _ := store.Allow(context.Background(), "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	// half way through the next window the previous one still counts for 2 requests
+	clock.Add(1500 * time.Millisecond)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.True(t, res.Allowed)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.True(t, res.Allowed)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 250*time.Millisecond, res.RetryAfter)
+
+	clock.Add(250 * time.Millisecond)
+	res, _ = store.Allow(context.Background(), "a")
+	assert.True(t, res.Allowed)
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Allow(context.Context, string) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	srv := NewServer(":0", ServerOptions{AccessLogDisabled: true})
+	ok := func(c Context) error { return c.NoContent(http.StatusOK) }
+	srv.Echo.GET("/ip", ok, NewRateLimitMiddleware(RateLimit{Rate: Rate{Limit: 1, Period: time.Minute}}))
+	srv.Echo.GET("/key", ok, NewRateLimitMiddleware(RateLimit{
+		Rate: Rate{Limit: 1, Period: time.Minute},
+		Key:  RateLimitByAPIKey("X-API-Key"),
+	}))
+	srv.Echo.GET("/route", ok, NewRateLimitMiddleware(RateLimit{Rate: Rate{Limit: 1, Period: time.Minute}, Key: RateLimitByRoute}))
+	srv.Echo.GET("/open", ok, NewRateLimitMiddleware(RateLimit{Store: failingRateLimitStore{}, FailOpen: true}))
+	srv.Echo.GET("/closed", ok, NewRateLimitMiddleware(RateLimit{Store: failingRateLimitStore{}}))
+
+	get := func(path, ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/ip", "10.0.0.1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, "0", rec.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "60", rec.Header().Get(HeaderRateLimitReset))
+	rec = get("/ip", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get(HeaderRetryAfter))
+	assert.JSONEq(t, `{"code":429,"message":"rate limit exceeded"}`, rec.Body.String())
+	assert.Equal(t, http.StatusOK, get("/ip", "10.0.0.2", "").Code)
+	spoofed := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	spoofed.RemoteAddr = "10.0.0.1:1234"
+	spoofed.Header.Set("X-Real-IP", "10.0.0.9")
+	rec = httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, spoofed)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "forwarding headers are not trusted")
+
+	assert.Equal(t, http.StatusOK, get("/key", "10.0.0.1", "k1").Code)
+	assert.Equal(t, http.StatusOK, get("/key", "10.0.0.1", "k2").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/key", "10.0.0.2", "k1").Code)
+	assert.Equal(t, http.StatusOK, get("/key", "10.0.0.3", "").Code, "falls back to the IP")
+
+	assert.Equal(t, http.StatusOK, get("/route", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/route", "10.0.0.2", "").Code)
+
+	assert.Equal(t, http.StatusOK, get("/open", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusInternalServerError, get("/closed", "10.0.0.1", "").Code)
+}
@@ -0,0 +1,238 @@
+package webservice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	handoffPublic = "public"
+	handoffAdmin  = "admin"
+
+	// envHandoffNames contains the names of the inherited listeners, colon separated, in file descriptor order.
+	envHandoffNames = "WEBSERVICE_LISTEN_NAMES"
+	// envHandoffPPID contains the PID of the parent process handing off the listeners.
+	envHandoffPPID = "WEBSERVICE_LISTEN_PPID"
+	// envHandoffReadyFD contains the file descriptor the new process writes to once it is ready to serve requests.
+	envHandoffReadyFD = "WEBSERVICE_READY_FD"
+
+	// handoffFDsStart is the first file descriptor passed to a child process by exec.Cmd.ExtraFiles.
+	handoffFDsStart = 3
+)
+
+var handoff struct {
+	once      sync.Once
+	listeners map[string]net.Listener
+	ready     *os.File
+}
+
+// loadHandoff reads the listeners inherited from a parent process.
+// The environment variables are cleared so they are not passed on to other child processes.
+func loadHandoff() {
+	handoff.once.Do(func() {
+		names := os.Getenv(envHandoffNames)
+		ppid := os.Getenv(envHandoffPPID)
+		readyFD := os.Getenv(envHandoffReadyFD)
+		os.Unsetenv(envHandoffNames)
+		os.Unsetenv(envHandoffPPID)
+		os.Unsetenv(envHandoffReadyFD)
+
+		if names == "" || ppid != strconv.Itoa(os.Getppid()) {
+			return
+		}
+		handoff.listeners = make(map[string]net.Listener)
+		for i, name := range strings.Split(names, ":") {
+			f := os.NewFile(uintptr(handoffFDsStart+i), name)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			handoff.listeners[name] = l
+		}
+		if fd, err := strconv.Atoi(readyFD); err == nil {
+			handoff.ready = os.NewFile(uintptr(fd), "ready")
+		}
+	})
+}
+
+// inheritedListener returns the listener with the given name passed by the parent process during a Restart.
+// Each listener can only be used once.
+func inheritedListener(name string) net.Listener {
+	loadHandoff()
+	l := handoff.listeners[name]
+	delete(handoff.listeners, name)
+	return l
+}
+
+// notifyHandoffReady tells the parent process, if any, that this process is ready to serve requests.
+func notifyHandoffReady() {
+	loadHandoff()
+	if handoff.ready == nil {
+		return
+	}
+	handoff.ready.Write([]byte{1})
+	handoff.ready.Close()
+	handoff.ready = nil
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Restart performs a zero-downtime restart by starting a new process which inherits the server's listeners.
+// Once the new process reports it is ready to serve requests this server is stopped, draining active connections, and
+// Start returns. The new process must use a Server with the same listener configuration (public and admin).
+// If the new process fails to become ready within the RestartTimeout it is killed and this server keeps running.
+// Only one restart runs at a time, an error is returned if a restart is already in progress.
+// Not supported on Windows.
+func (srv *Server) Restart() error {
+	if atomic.LoadUint32(&srv.running) != 1 {
+		return fmt.Errorf("server is not running")
+	}
+	if !atomic.CompareAndSwapUint32(&srv.restart.inProgress, 0, 1) {
+		return fmt.Errorf("restart already in progress")
+	}
+	defer atomic.StoreUint32(&srv.restart.inProgress, 0)
+
+	srv.listeners.mu.Lock()
+	var names []string
+	var files []*os.File
+	for _, name := range []string{handoffPublic, handoffAdmin} {
+		l, ok := srv.listeners.active[name]
+		if !ok {
+			continue
+		}
+		fl, ok := l.(filer)
+		if !ok {
+			srv.listeners.mu.Unlock()
+			closeFiles(files)
+			return fmt.Errorf("listener does not support handoff [name:%s]", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			srv.listeners.mu.Unlock()
+			closeFiles(files)
+			return fmt.Errorf("failed to get listener file [name:%s]; %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	srv.listeners.mu.Unlock()
+	defer closeFiles(files)
+
+	command := srv.restart.command
+	if len(command) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to find executable; %w", err)
+		}
+		command = append([]string{exe}, os.Args[1:]...)
+	}
+
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create ready pipe; %w", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, notify)
+	cmd.Env = append(os.Environ(),
+		envHandoffNames+"="+strings.Join(names, ":"),
+		envHandoffPPID+"="+strconv.Itoa(os.Getpid()),
+		envHandoffReadyFD+"="+strconv.Itoa(handoffFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	notify.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start new process; %w", err)
+	}
+	pid := cmd.Process.Pid
+	srv.log.Infof("webserver: restarting [pid:%d]", pid)
+
+	if err := waitHandoffReady(ready, srv.restart.timeout); err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("new process failed to start [pid:%d]; %w", pid, err)
+	}
+	cmd.Process.Release()
+	srv.log.Infof("webserver: new process ready, stopping [pid:%d]", pid)
+
+	// the unix socket files now belong to the new process.
+	srv.listeners.mu.Lock()
+	for _, l := range srv.listeners.active {
+		if ul, ok := l.(interface{ SetUnlinkOnClose(bool) }); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	srv.listeners.mu.Unlock()
+
+	return srv.Stop()
+}
+
+func waitHandoffReady(ready *os.File, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		var b = make([]byte, 1)
+		if _, err := ready.Read(b); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("process exited before being ready")
+			}
+			done <- err
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timeout")
+	}
+}
+
+// watchRestartSignal calls Restart each time the restart signal is received. Returns a function to stop watching.
+func (srv *Server) watchRestartSignal() func() {
+	var signals = make(chan os.Signal, 1)
+	var done = make(chan struct{})
+	signal.Notify(signals, srv.restart.signal)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := srv.Restart(); err != nil {
+					srv.log.Errorf("webserver: restart failed: %+v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -9,11 +9,15 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vredens/go-webservice"
 	"gitlab.com/vredens/go-logger/v2"
 )
@@ -257,3 +261,188 @@ func TestServer_Logs(t *testing.T) {
 	assert.Nil(t, waitOnChan(doneStart), "failed to terminate server")
 	assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
 }
+
+func TestServer_AdminListener(t *testing.T) {
+	var srv = webservice.NewServer("127.0.0.1:8003", webservice.ServerOptions{AdminAddress: "127.0.0.1:8004"})
+	srv.RegisterHealthRoutes("/_")
+	srv.Echo.GET("/api", func(ctx webservice.Context) error {
+		return ctx.NoContent(200)
+	})
+	var doneStart = serverStart(srv)
+
+	t.Run("public listener", func(t *testing.T) {
+		var cli = webservice.NewClient("http://127.0.0.1:8003")
+		var s, _, err = cli.Request(context.TODO(), http.MethodGet, "/api", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, s)
+		s, _, err = cli.Request(context.TODO(), http.MethodGet, "/_/health", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 404, s)
+	})
+
+	t.Run("admin listener", func(t *testing.T) {
+		var cli = webservice.NewClient("http://127.0.0.1:8004")
+		var s, _, err = cli.Request(context.TODO(), http.MethodGet, "/_/health", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, s)
+		s, _, err = cli.Request(context.TODO(), http.MethodGet, "/api", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 404, s)
+	})
+
+	var doneStop = serverStop(srv)
+	assert.Nil(t, waitOnChan(doneStart), "failed to start server")
+	assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
+}
+
+func TestServer_Listeners(t *testing.T) {
+	t.Run("unix socket", func(t *testing.T) {
+		var path = filepath.Join(t.TempDir(), "server.sock")
+		var srv = webservice.NewServer(webservice.AddressUnixPrefix+path, webservice.ServerOptions{
+			UnixSocket: webservice.UnixSocketOptions{Mode: 0600},
+		})
+		srv.RegisterHealthRoutes("/_")
+		var doneStart = serverStart(srv)
+
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		entries, err := os.ReadDir(filepath.Dir(path))
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1, "private socket directory removed")
+
+		var cli = webservice.NewCustomClient("http://unix", webservice.ClientOptions{
+			Conn: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", path)
+				},
+			}},
+		})
+		s, _, err := cli.Request(context.TODO(), http.MethodGet, "/_/health", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, s)
+
+		var doneStop = serverStop(srv)
+		assert.Nil(t, waitOnChan(doneStart), "failed to start server")
+		assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "socket removed on stop")
+	})
+
+	t.Run("unix socket in use", func(t *testing.T) {
+		var path = filepath.Join(t.TempDir(), "server.sock")
+		live, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer live.Close()
+
+		var srv = webservice.NewServer(webservice.AddressUnixPrefix+path, webservice.ServerOptions{})
+		assert.ErrorContains(t, srv.Start(), "in use")
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err, "live socket kept")
+		conn.Close()
+	})
+
+	t.Run("stale unix socket", func(t *testing.T) {
+		var path = filepath.Join(t.TempDir(), "server.sock")
+		stale, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		var srv = webservice.NewServer(webservice.AddressUnixPrefix+path, webservice.ServerOptions{})
+		srv.RegisterHealthRoutes("/_")
+		var doneStart = serverStart(srv)
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		conn.Close()
+
+		var doneStop = serverStop(srv)
+		assert.Nil(t, waitOnChan(doneStart), "failed to start server")
+		assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
+	})
+
+	t.Run("pre-opened listener", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		var srv = webservice.NewServer(l.Addr().String(), webservice.ServerOptions{Listener: l})
+		srv.RegisterHealthRoutes("/_")
+		var doneStart = serverStart(srv)
+
+		var cli = webservice.NewClient("http://" + l.Addr().String())
+		s, _, err := cli.Request(context.TODO(), http.MethodGet, "/_/health", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, s)
+
+		var doneStop = serverStop(srv)
+		assert.Nil(t, waitOnChan(doneStart), "failed to start server")
+		assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
+	})
+}
+
+func newRestartServer(name string, opts webservice.ServerOptions) *webservice.Server {
+	var srv = webservice.NewServer("127.0.0.1:8005", opts)
+	srv.Echo.GET("/who", func(ctx webservice.Context) error {
+		return ctx.String(200, name)
+	})
+	srv.Echo.POST("/stop", func(ctx webservice.Context) error {
+		go srv.Stop()
+		return ctx.NoContent(204)
+	})
+	return srv
+}
+
+// TestServer_RestartHelper is the new process started by TestServer_Restart.
+func TestServer_RestartHelper(t *testing.T) {
+	if os.Getenv("WEBSERVICE_TEST_RESTART_HELPER") != "1" {
+		t.Skip("helper process for TestServer_Restart")
+	}
+	var srv = newRestartServer("child", webservice.ServerOptions{})
+	assert.Nil(t, waitOnChan(serverStart(srv)))
+}
+
+func TestServer_Restart(t *testing.T) {
+	t.Setenv("WEBSERVICE_TEST_RESTART_HELPER", "1")
+	var srv = newRestartServer("parent", webservice.ServerOptions{
+		RestartCommand: []string{os.Args[0], "-test.run=^TestServer_RestartHelper$"},
+	})
+	var cli = webservice.NewClient("http://127.0.0.1:8005")
+
+	assert.Error(t, srv.Restart(), "restart requires a running server")
+
+	var doneStart = serverStart(srv)
+	var _, res, err = cli.Request(context.TODO(), http.MethodGet, "/who", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "parent", string(res))
+
+	assert.Nil(t, srv.Restart())
+	assert.Nil(t, waitOnChan(doneStart), "failed to stop parent server")
+
+	_, res, err = cli.Request(context.TODO(), http.MethodGet, "/who", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "child", string(res))
+
+	s, _, err := cli.Request(context.TODO(), http.MethodPost, "/stop", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 204, s)
+}
+
+func TestServer_RestartInProgress(t *testing.T) {
+	var srv = webservice.NewServer("127.0.0.1:0", webservice.ServerOptions{
+		RestartCommand: []string{"sleep", "10"},
+		RestartTimeout: 500 * time.Millisecond,
+	})
+	var doneStart = serverStart(srv)
+
+	var restarted = make(chan error)
+	go func() {
+		restarted <- srv.Restart()
+	}()
+	<-time.After(100 * time.Millisecond)
+	assert.ErrorContains(t, srv.Restart(), "restart already in progress")
+	assert.ErrorContains(t, waitOnChan(restarted), "failed to start")
+	assert.ErrorContains(t, srv.Restart(), "failed to start", "new restarts are allowed after the previous one finished")
+
+	var doneStop = serverStop(srv)
+	assert.Nil(t, waitOnChan(doneStart), "failed to start server")
+	assert.Nil(t, waitOnChan(doneStop), "failed to stop server")
+}
@@ -0,0 +1,198 @@
+package webservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/vredens/go-logger/v2"
+)
+
+// certReloader holds the server certificate loaded from the certificate and key files.
+// The certificate is replaced atomically so it can be reloaded while serving requests.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      logger.SLogger
+	cert     atomic.Pointer[tls.Certificate]
+	mu       sync.Mutex
+	modTime  [2]time.Time
+}
+
+func newCertReloader(certFile, keyFile string, log logger.SLogger) *certReloader {
+	return &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      log,
+	}
+}
+
+// GetCertificate can be used as the tls.Config.GetCertificate function.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := r.cert.Load()
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+	return cert, nil
+}
+
+// Load reads the certificate and key files. The previous certificate is kept if loading fails.
+func (r *certReloader) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	return r.load(modTime)
+}
+
+// reloadIfModified loads the certificate if any of the files changed since the last successful load.
+func (r *certReloader) reloadIfModified() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	if modTime == r.modTime {
+		return nil
+	}
+	return r.load(modTime)
+}
+
+func (r *certReloader) load(modTime [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate; %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate; %w", err)
+		}
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	r.log.Infof("tls: certificate loaded [file:%s] [serial:%s] [expires:%s]", r.certFile, cert.Leaf.SerialNumber.Text(16), cert.Leaf.NotAfter.Format(time.RFC3339))
+
+	return nil
+}
+
+func (r *certReloader) modTimes() (modTime [2]time.Time, err error) {
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, fmt.Errorf("failed to read TLS file info; %w", err)
+		}
+		modTime[i] = info.ModTime()
+	}
+	return modTime, nil
+}
+
+// watch checks the certificate files for changes every interval until done is closed.
+func (r *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reloadIfModified(); err != nil {
+				r.log.Errorf("tls: certificate reload failed: %+v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// ReloadTLS reloads the TLS certificate and key files.
+// The current certificate is kept if the files can not be loaded.
+func (srv *Server) ReloadTLS() error {
+	if srv.tls.certs == nil {
+		return fmt.Errorf("server has no TLS certificate files configured")
+	}
+	return srv.tls.certs.Load()
+}
+
+// tlsConfig for the public listener.
+func (srv *Server) tlsConfig() (*tls.Config, error) {
+	if srv.tls.err != nil {
+		return nil, srv.tls.err
+	}
+	config := &tls.Config{}
+	if srv.tls.config != nil {
+		config = srv.tls.config.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	switch {
+	case srv.tls.getCertificate != nil:
+		config.GetCertificate = srv.tls.getCertificate
+	case srv.tls.certs != nil:
+		if err := srv.tls.certs.Load(); err != nil {
+			return nil, err
+		}
+		config.GetCertificate = srv.tls.certs.GetCertificate
+	case len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil:
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+
+	return config, nil
+}
+
+// newServerTLSConfig creates the base TLS configuration from the server options.
+func newServerTLSConfig(opts ServerOptions) (*tls.Config, error) {
+	if opts.TLSConfig == nil && opts.TLSClientAuth == tls.NoClientCert && opts.TLSClientCAFile == "" &&
+		opts.TLSMinVersion == 0 && len(opts.TLSCipherSuites) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	if opts.TLSClientAuth != tls.NoClientCert {
+		config.ClientAuth = opts.TLSClientAuth
+	}
+	if opts.TLSClientCAFile != "" {
+		pool, err := loadCertPool(opts.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if opts.TLSMinVersion != 0 {
+		config.MinVersion = opts.TLSMinVersion
+	}
+	if len(opts.TLSCipherSuites) > 0 {
+		config.CipherSuites = opts.TLSCipherSuites
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file; %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
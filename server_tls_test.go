@@ -0,0 +1,206 @@
+package webservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by the parent or a self-signed CA certificate if parent is nil.
+func newTestCert(t *testing.T, parent *testCert, serial int64, template x509.Certificate) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	signer, signerCert := key, &template
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerCert = parent.key, parent.cert
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, signerCert, &key.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c testCert) writeFiles(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, c.certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+// startTestServer runs the server until the end of the test.
+// Servers should use a pre-opened listener so requests can be sent right away.
+func startTestServer(t *testing.T, srv *Server) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, srv.Stop())
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestServer_TLSReload(t *testing.T) {
+	var dir = t.TempDir()
+	var ca = newTestCert(t, nil, 1, x509.Certificate{Subject: pkix.Name{CommonName: "ca"}})
+	certFile, keyFile := newTestCert(t, &ca, 10, x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}).writeFiles(t, dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(l.Addr().String(), ServerOptions{
+		Listener:          l,
+		TLSCertFile:       certFile,
+		TLSKeyFile:        keyFile,
+		TLSReloadInterval: 10 * time.Millisecond,
+		AccessLogDisabled: true,
+	})
+	srv.Echo.GET("/", func(c Context) error { return c.NoContent(http.StatusOK) })
+	startTestServer(t, srv)
+
+	var pool = x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	var cli = &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		DisableKeepAlives: true,
+	}}
+	serial := func() int64 {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://"+l.Addr().String()+"/", nil)
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	assert.Equal(t, int64(10), serial())
+
+	// the modification time resolution of some file systems is coarse.
+	time.Sleep(10 * time.Millisecond)
+	newTestCert(t, &ca, 11, x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}).writeFiles(t, dir)
+	assert.Eventually(t, func() bool { return serial() == 11 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0600))
+	assert.Error(t, srv.ReloadTLS())
+	assert.Equal(t, int64(11), serial(), "previous certificate is kept when reloading fails")
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	var dir = t.TempDir()
+	var ca = newTestCert(t, nil, 1, x509.Certificate{Subject: pkix.Name{CommonName: "ca"}})
+	var other = newTestCert(t, nil, 2, x509.Certificate{Subject: pkix.Name{CommonName: "other ca"}})
+	certFile, keyFile := newTestCert(t, &ca, 10, x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}).writeFiles(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM, 0600))
+
+	spiffe, _ := url.Parse("spiffe://example.org/ns/default/sa/billing")
+	client := newTestCert(t, &ca, 20, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames: []string{"billing.local"},
+		URIs:     []*url.URL{spiffe},
+	})
+	intruder := newTestCert(t, &other, 30, x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(l.Addr().String(), ServerOptions{
+		Listener:          l,
+		TLSCertFile:       certFile,
+		TLSKeyFile:        keyFile,
+		TLSClientAuth:     tls.RequireAndVerifyClientCert,
+		TLSClientCAFile:   caFile,
+		TLSMinVersion:     tls.VersionTLS13,
+		AccessLogDisabled: true,
+	})
+	srv.Echo.GET("/", func(c Context) error {
+		id, ok := GetPeerIdentity(c)
+		if !ok {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"identity": Identity(c),
+			"subject":  id.Subject,
+			"dns":      id.DNSNames,
+			"spiffe":   id.SPIFFEID,
+		})
+	}, NewPeerIdentityMiddleware(true))
+	startTestServer(t, srv)
+
+	request := func(cert testCert) (*http.Response, error) {
+		pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+		require.NoError(t, err)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+		cli := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{pair},
+		}}}
+		return cli.Get("https://" + l.Addr().String() + "/")
+	}
+
+	res, err := request(client)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"identity": "spiffe:spiffe://example.org/ns/default/sa/billing",
+		"subject": "CN=billing,O=Example",
+		"dns": ["billing.local"],
+		"spiffe": "spiffe://example.org/ns/default/sa/billing"
+	}`, string(body))
+
+	_, err = request(intruder)
+	assert.Error(t, err, "certificates from unknown CAs are rejected")
+}
+
+func TestPeerIdentity_String(t *testing.T) {
+	assert.Equal(t, "spiffe:spiffe://example.org/billing", PeerIdentity{CommonName: "billing", SPIFFEID: "spiffe://example.org/billing"}.String())
+	assert.Equal(t, "cert:billing", PeerIdentity{CommonName: "billing"}.String())
+}
@@ -0,0 +1,128 @@
+package webservice
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signature algorithms, named as in the HTTP Message Signatures algorithm registry (RFC 9421).
+const (
+	SigHMACSHA256      = "hmac-sha256"
+	SigEd25519         = "ed25519"
+	SigECDSAP256SHA256 = "ecdsa-p256-sha256"
+	SigRSAPSSSHA512    = "rsa-pss-sha512"
+	SigRSAv15SHA256    = "rsa-v1_5-sha256"
+)
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// keyAlgorithm returns the default signature algorithm of a key: hmac-sha256 for []byte secrets, ed25519 for Ed25519
+// keys, ecdsa-p256-sha256 for P-256 ECDSA keys and rsa-pss-sha512 for RSA keys. Works with private and public keys.
+func keyAlgorithm(key any) (string, error) {
+	switch k := key.(type) {
+	case []byte:
+		return SigHMACSHA256, nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return SigEd25519, nil
+	case *ecdsa.PrivateKey:
+		return keyAlgorithm(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("unsupported ECDSA curve, only P-256 is supported")
+		}
+		return SigECDSAP256SHA256, nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return SigRSAPSSSHA512, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
+
+// signMessage signs a message with a private key or secret.
+func signMessage(alg string, key any, message []byte) ([]byte, error) {
+	switch alg {
+	case SigHMACSHA256:
+		if secret, ok := key.([]byte); ok {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(message)
+			return mac.Sum(nil), nil
+		}
+	case SigEd25519:
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return ed25519.Sign(k, message), nil
+		}
+	case SigECDSAP256SHA256:
+		if k, ok := key.(*ecdsa.PrivateKey); ok && k.Curve == elliptic.P256() {
+			digest := sha256.Sum256(message)
+			r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			signature := make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+			return signature, nil
+		}
+	case SigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PrivateKey); ok {
+			digest := sha512.Sum512(message)
+			return rsa.SignPSS(rand.Reader, k, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+		}
+	case SigRSAv15SHA256:
+		if k, ok := key.(*rsa.PrivateKey); ok {
+			digest := sha256.Sum256(message)
+			return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	return nil, fmt.Errorf("invalid key type %T for algorithm %s", key, alg)
+}
+
+// verifyMessage verifies the signature of a message with a public key or secret.
+func verifyMessage(alg string, key any, message, signature []byte) error {
+	var valid bool
+	switch alg {
+	case SigHMACSHA256:
+		if secret, ok := key.([]byte); ok {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(message)
+			valid = hmac.Equal(signature, mac.Sum(nil))
+		}
+	case SigEd25519:
+		if k, ok := key.(ed25519.PublicKey); ok {
+			valid = len(signature) == ed25519.SignatureSize && ed25519.Verify(k, message, signature)
+		}
+	case SigECDSAP256SHA256:
+		if k, ok := key.(*ecdsa.PublicKey); ok && k.Curve == elliptic.P256() && len(signature) == 64 {
+			digest := sha256.Sum256(message)
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			valid = ecdsa.Verify(k, digest[:], r, s)
+		}
+	case SigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			digest := sha512.Sum512(message)
+			valid = rsa.VerifyPSS(k, crypto.SHA512, digest[:], signature, &rsa.PSSOptions{SaltLength: 64}) == nil
+		}
+	case SigRSAv15SHA256:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			digest := sha256.Sum256(message)
+			valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if !valid {
+		return errInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package webservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderHTTPSignature contains the HTTP message signatures of a request (RFC 9421).
+	HeaderHTTPSignature = "Signature"
+	// HeaderHTTPSignatureInput contains the covered components and parameters of the HTTP message signatures.
+	HeaderHTTPSignatureInput = "Signature-Input"
+	// HeaderContentDigest contains the digest of the request body (RFC 9530).
+	HeaderContentDigest = "Content-Digest"
+)
+
+// HTTPSignature settings for signing requests with HTTP Message Signatures (RFC 9421).
+type HTTPSignature struct {
+	// KeyID sent in the keyid parameter.
+	KeyID string
+	// Key signing the requests: a []byte secret, an ed25519.PrivateKey, a P-256 *ecdsa.PrivateKey or an
+	// *rsa.PrivateKey.
+	Key any
+	// Algorithm of the signature, one of the Sig* constants. Defaults to the algorithm of the key, rsa-pss-sha512 for
+	// RSA keys.
+	Algorithm string
+	// Label of the signature. Defaults to "sig1".
+	Label string
+	// Components covered by the signature, derived components like "@method" or lowercase header names. Defaults to
+	// "@method", "@authority", "@path" and "@query", plus "content-digest" for requests with a body.
+	Components []string
+	// Expires sets the expires parameter, if not zero.
+	Expires time.Duration
+	// Tag sets the tag parameter, if not empty.
+	Tag string
+}
+
+// NewHTTPSignatureMiddleware signs the requests of a client with HTTP Message Signatures (RFC 9421). The
+// Content-Digest header is added if covered by the signature.
+func NewHTTPSignatureMiddleware(params HTTPSignature) (RequestMiddleware, error) {
+	if params.Algorithm == "" {
+		alg, err := keyAlgorithm(params.Key)
+		if err != nil {
+			return nil, err
+		}
+		params.Algorithm = alg
+	}
+	if params.Label == "" {
+		params.Label = "sig1"
+	}
+	return func(ctx context.Context, req *http.Request) (*http.Request, error) {
+		return req, params.sign(req, time.Now())
+	}, nil
+}
+
+func (params HTTPSignature) sign(req *http.Request, now time.Time) error {
+	body, err := bufferRequestBody(req)
+	if err != nil {
+		return err
+	}
+	components := params.Components
+	if components == nil {
+		components = []string{"@method", "@authority", "@path", "@query"}
+		if len(body) > 0 {
+			components = append(components, "content-digest")
+		}
+	}
+	if slices.Contains(components, "content-digest") {
+		req.Header.Set(HeaderContentDigest, contentDigest(body))
+	}
+
+	var sigParams strings.Builder
+	sigParams.WriteString("(")
+	for i, component := range components {
+		if i > 0 {
+			sigParams.WriteString(" ")
+		}
+		sigParams.WriteString(strconv.Quote(component))
+	}
+	sigParams.WriteString(");created=" + strconv.FormatInt(now.Unix(), 10))
+	if params.Expires > 0 {
+		sigParams.WriteString(";expires=" + strconv.FormatInt(now.Add(params.Expires).Unix(), 10))
+	}
+	if params.KeyID != "" {
+		sigParams.WriteString(";keyid=" + strconv.Quote(params.KeyID))
+	}
+	sigParams.WriteString(";alg=" + strconv.Quote(params.Algorithm))
+	if params.Tag != "" {
+		sigParams.WriteString(";tag=" + strconv.Quote(params.Tag))
+	}
+
+	base, err := signatureBase(req, components, sigParams.String())
+	if err != nil {
+		return err
+	}
+	signature, err := signMessage(params.Algorithm, params.Key, base)
+	if err != nil {
+		return fmt.Errorf("failed to sign request; %w", err)
+	}
+	req.Header.Set(HeaderHTTPSignatureInput, params.Label+"="+sigParams.String())
+	req.Header.Set(HeaderHTTPSignature, params.Label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// HTTPSignatureKey used for verifying HTTP message signatures.
+type HTTPSignatureKey struct {
+	// Algorithm of the signatures, one of the Sig* constants.
+	Algorithm string
+	// Key is a []byte secret, an ed25519.PublicKey, an *ecdsa.PublicKey or an *rsa.PublicKey.
+	Key any
+}
+
+// HTTPSignatureAuth settings.
+type HTTPSignatureAuth struct {
+	// Keys maps key IDs to their verification keys.
+	Keys map[string]HTTPSignatureKey
+	// RequiredComponents which must be covered by the signature. Defaults to "@method", "@authority" and "@path".
+	// Requests with a body must always cover "content-digest".
+	RequiredComponents []string
+	// MaxAge of signatures, from their created parameter. Defaults to 5 minutes.
+	MaxAge time.Duration
+	// MaxBodySize is the maximum number of bytes read from the request body for verifying its digest.
+	// Defaults to 1MB.
+	MaxBodySize int64
+}
+
+// NewHTTPSignatureAuthMiddleware only accepts requests with an HTTP message signature (RFC 9421) from one of the
+// configured keys, such as the ones signed by NewHTTPSignatureMiddleware.
+func NewHTTPSignatureAuthMiddleware(params HTTPSignatureAuth) echo.MiddlewareFunc {
+	if params.RequiredComponents == nil {
+		params.RequiredComponents = []string{"@method", "@authority", "@path"}
+	}
+	if params.MaxAge <= 0 {
+		params.MaxAge = 5 * time.Minute
+	}
+	if params.MaxBodySize <= 0 {
+		params.MaxBodySize = 1 << 20
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			req := c.Request()
+			inputs := parseDictionary(req.Header.Get(HeaderHTTPSignatureInput))
+			signatures := parseDictionary(req.Header.Get(HeaderHTTPSignature))
+
+			// use the first signature with a known key
+			labels := make([]string, 0, len(inputs))
+			for label := range inputs {
+				labels = append(labels, label)
+			}
+			slices.Sort(labels)
+			for _, label := range labels {
+				input, err := parseSignatureInput(inputs[label])
+				if err != nil {
+					return errUnauthorized
+				}
+				key, ok := params.Keys[input.params["keyid"]]
+				if !ok {
+					continue
+				}
+				if err := params.verify(req, key, input, signatures[label]); err != nil {
+					return err
+				}
+				setIdentity(c, "httpsig:"+input.params["keyid"])
+				return next(c)
+			}
+			return errUnauthorized
+		}
+	}
+}
+
+func (params HTTPSignatureAuth) verify(req *http.Request, key HTTPSignatureKey, input signatureInput, encoded string) error {
+	if alg, ok := input.params["alg"]; ok && alg != key.Algorithm {
+		return errUnauthorized
+	}
+	created, err := strconv.ParseInt(input.params["created"], 10, 64)
+	if err != nil {
+		return errUnauthorized
+	}
+	if age := time.Since(time.Unix(created, 0)); age > params.MaxAge || age < -params.MaxAge {
+		return errUnauthorized
+	}
+	if expires, ok := input.params["expires"]; ok {
+		ts, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > ts {
+			return errUnauthorized
+		}
+	}
+	for _, component := range params.RequiredComponents {
+		if !slices.Contains(input.components, component) {
+			return errUnauthorized
+		}
+	}
+
+	body, err := readRequestBody(req, params.MaxBodySize)
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 && !slices.Contains(input.components, "content-digest") {
+		return errUnauthorized
+	}
+	if slices.Contains(input.components, "content-digest") && !verifyContentDigest(req.Header.Get(HeaderContentDigest), body) {
+		return errUnauthorized
+	}
+
+	if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+		return errUnauthorized
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+	if err != nil {
+		return errUnauthorized
+	}
+	base, err := signatureBase(req, input.components, input.raw)
+	if err != nil {
+		return errUnauthorized
+	}
+	if err := verifyMessage(key.Algorithm, key.Key, base, signature); err != nil {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// signatureBase creates the signature base of a request (RFC 9421 section 2.5).
+func signatureBase(req *http.Request, components []string, sigParams string) ([]byte, error) {
+	var base strings.Builder
+	for _, component := range components {
+		value, err := componentValue(req, component)
+		if err != nil {
+			return nil, err
+		}
+		base.WriteString(strconv.Quote(component) + ": " + value + "\n")
+	}
+	base.WriteString(`"@signature-params": ` + sigParams)
+	return []byte(base.String()), nil
+}
+
+// componentValue returns the value of a derived component or header field of a request. Works for both client and
+// server requests.
+func componentValue(req *http.Request, component string) (string, error) {
+	scheme := req.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+	authority := req.Host
+	if authority == "" {
+		authority = req.URL.Host
+	}
+	authority = strings.ToLower(authority)
+
+	switch component {
+	case "@method":
+		return req.Method, nil
+	case "@scheme":
+		return scheme, nil
+	case "@authority":
+		return authority, nil
+	case "@target-uri":
+		return scheme + "://" + authority + req.URL.RequestURI(), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		if path := req.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") || component != strings.ToLower(component) {
+		return "", fmt.Errorf("unsupported signature component %q", component)
+	}
+	values := req.Header.Values(component)
+	if len(values) == 0 {
+		return "", fmt.Errorf("missing signature component %q", component)
+	}
+	trimmed := make([]string, len(values))
+	for i := range values {
+		trimmed[i] = strings.TrimSpace(values[i])
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// contentDigest returns the Content-Digest header value of a body (RFC 9530).
+func contentDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+// verifyContentDigest checks the sha-256 or sha-512 digests of a Content-Digest header.
+func verifyContentDigest(header string, body []byte) bool {
+	var verified bool
+	for alg, value := range parseDictionary(header) {
+		var digest []byte
+		switch alg {
+		case "sha-256":
+			sum := sha256.Sum256(body)
+			digest = sum[:]
+		case "sha-512":
+			sum := sha512.Sum512(body)
+			digest = sum[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(value), []byte(":"+base64.StdEncoding.EncodeToString(digest)+":")) != 1 {
+			return false
+		}
+		verified = true
+	}
+	return verified
+}
+
+type signatureInput struct {
+	raw        string
+	components []string
+	params     map[string]string
+}
+
+// parseSignatureInput parses a member of the Signature-Input header, e.g. ("@method" "@path");created=1;keyid="k1".
+// Components with parameters are not supported.
+func parseSignatureInput(raw string) (signatureInput, error) {
+	input := signatureInput{raw: raw, params: make(map[string]string)}
+	end := strings.IndexByte(raw, ')')
+	if !strings.HasPrefix(raw, "(") || end < 0 {
+		return input, errors.New("invalid signature input")
+	}
+	for _, item := range strings.Fields(raw[1:end]) {
+		component, err := strconv.Unquote(item)
+		if err != nil {
+			return input, fmt.Errorf("invalid signature component %s", item)
+		}
+		input.components = append(input.components, component)
+	}
+	for _, param := range splitOutsideQuotes(raw[end+1:], ';') {
+		if param = strings.TrimSpace(param); param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		input.params[name] = value
+	}
+	return input, nil
+}
+
+// parseDictionary parses the members of a structured field dictionary, keeping the raw value of each member.
+func parseDictionary(header string) map[string]string {
+	members := make(map[string]string)
+	for _, member := range splitOutsideQuotes(header, ',') {
+		name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if ok && name != "" {
+			members[name] = value
+		}
+	}
+	return members
+}
+
+// splitOutsideQuotes splits a string on the separator, ignoring separators in quoted strings or parentheses.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	var quoted, escaped bool
+	var depth, start int
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,146 @@
+package webservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSignatureMiddleware(t *testing.T) {
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	srv := newAuthTestServer(NewHTTPSignatureAuthMiddleware(HTTPSignatureAuth{
+		Keys: map[string]HTTPSignatureKey{
+			"hmac":  {Algorithm: SigHMACSHA256, Key: []byte("secret")},
+			"ed":    {Algorithm: SigEd25519, Key: edPublic},
+			"ec":    {Algorithm: SigECDSAP256SHA256, Key: &ecPrivate.PublicKey},
+			"rsa":   {Algorithm: SigRSAPSSSHA512, Key: &rsaPrivate.PublicKey},
+			"rsa15": {Algorithm: SigRSAv15SHA256, Key: &rsaPrivate.PublicKey},
+		},
+	}))
+	signed := func(t *testing.T, params HTTPSignature, body string) *http.Request {
+		sign, err := NewHTTPSignatureMiddleware(params)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/test?a=b", strings.NewReader(body))
+		_, err = sign(context.Background(), req)
+		require.NoError(t, err)
+		return req
+	}
+
+	for _, params := range []HTTPSignature{
+		{KeyID: "hmac", Key: []byte("secret")},
+		{KeyID: "ed", Key: edPrivate},
+		{KeyID: "ec", Key: ecPrivate},
+		{KeyID: "rsa", Key: rsaPrivate},
+		{KeyID: "rsa15", Key: rsaPrivate, Algorithm: SigRSAv15SHA256},
+	} {
+		t.Run(params.KeyID, func(t *testing.T) {
+			req := signed(t, params, "payload")
+			assert.Equal(t, "sha-256=:I59Z7VXnN8dxR89VrQwbAwttfudIp0JpUvm4UtWpNeU=:", req.Header.Get(HeaderContentDigest))
+			assert.Contains(t, req.Header.Get(HeaderHTTPSignatureInput), `sig1=("@method" "@authority" "@path" "@query" "content-digest");created=`)
+			rec := serve(srv, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "httpsig:"+params.KeyID, rec.Body.String())
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "ed", Key: edPrivate}, "payload")
+		req.Body = http.NoBody
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("tampered query", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "ed", Key: edPrivate}, "")
+		req.URL.RawQuery = "a=c"
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("body not covered", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "ed", Key: edPrivate, Components: []string{"@method", "@authority", "@path"}}, "payload")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("missing required component", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "ed", Key: edPrivate, Components: []string{"@method"}}, "")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("algorithm mismatch", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "rsa", Key: rsaPrivate, Algorithm: SigRSAv15SHA256}, "")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/test", nil)
+		params := HTTPSignature{KeyID: "ed", Key: edPrivate, Algorithm: SigEd25519, Label: "sig1"}
+		require.NoError(t, params.sign(req, time.Now().Add(-time.Hour)))
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		req := signed(t, HTTPSignature{KeyID: "other", Key: []byte("secret")}, "")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("missing header component", func(t *testing.T) {
+		sign, err := NewHTTPSignatureMiddleware(HTTPSignature{Key: []byte("secret"), Components: []string{"content-type"}})
+		require.NoError(t, err)
+		_, err = sign(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.ErrorContains(t, err, `missing signature component "content-type"`)
+	})
+}
+
+func TestClient_HTTPSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	srv := newAuthTestServer(NewHTTPSignatureAuthMiddleware(HTTPSignatureAuth{
+		Keys: map[string]HTTPSignatureKey{"ed": {Algorithm: SigEd25519, Key: public}},
+	}))
+	api := httptest.NewServer(srv.Echo)
+	defer api.Close()
+
+	sign, err := NewHTTPSignatureMiddleware(HTTPSignature{KeyID: "ed", Key: private, Components: []string{
+		"@method", "@target-uri", "@authority", "@path", "content-digest", "content-type",
+	}})
+	require.NoError(t, err)
+	cli := NewCustomClient(api.URL, ClientOptions{Middlewares: []RequestMiddleware{sign}})
+	status, body, err := cli.JSONRequest(context.Background(), http.MethodPost, "/test", map[string]string{"a": "b"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "httpsig:ed", string(body))
+}
+
+func TestParseSignatureInput(t *testing.T) {
+	inputs := parseDictionary(`sig1=("@method" "@path");created=1618884473;keyid="test-key-rsa-pss";tag="a,b", sig2=("@authority");alg="ed25519"`)
+	require.Len(t, inputs, 2)
+
+	input, err := parseSignatureInput(inputs["sig1"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@method", "@path"}, input.components)
+	assert.Equal(t, map[string]string{"created": "1618884473", "keyid": "test-key-rsa-pss", "tag": "a,b"}, input.params)
+	assert.Equal(t, `("@method" "@path");created=1618884473;keyid="test-key-rsa-pss";tag="a,b"`, input.raw)
+
+	input, err = parseSignatureInput(inputs["sig2"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@authority"}, input.components)
+
+	_, err = parseSignatureInput(`("@query-param";name="a")`)
+	assert.Error(t, err)
+}
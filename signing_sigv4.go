@@ -0,0 +1,241 @@
+package webservice
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderAmzDate contains the time a SigV4 signed request was signed, e.g. 20150830T123600Z.
+	HeaderAmzDate = "X-Amz-Date"
+	// HeaderAmzContentSHA256 contains the hex encoded SHA-256 digest of the body of a SigV4 signed request.
+	HeaderAmzContentSHA256 = "X-Amz-Content-Sha256"
+	// HeaderAmzSecurityToken contains the session token of temporary credentials.
+	HeaderAmzSecurityToken = "X-Amz-Security-Token"
+
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// SigV4 settings for signing requests with AWS Signature Version 4.
+type SigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken of temporary credentials, if any.
+	SessionToken string
+	// Region and Service of the credential scope, e.g. "us-east-1" and "execute-api".
+	Region  string
+	Service string
+}
+
+// NewSigV4SigningMiddleware signs the requests of a client with AWS Signature Version 4, in the Authorization header.
+// The host, X-Amz-Date, X-Amz-Content-Sha256 and, if set, X-Amz-Security-Token headers are signed.
+func NewSigV4SigningMiddleware(params SigV4) RequestMiddleware {
+	return func(ctx context.Context, req *http.Request) (*http.Request, error) {
+		return req, params.sign(req, time.Now())
+	}
+}
+
+func (params SigV4) sign(req *http.Request, now time.Time) error {
+	body, err := bufferRequestBody(req)
+	if err != nil {
+		return err
+	}
+	now = now.UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set(HeaderAmzDate, now.Format(sigV4TimeFormat))
+	req.Header.Set(HeaderAmzContentSHA256, payloadHash)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if params.SessionToken != "" {
+		req.Header.Set(HeaderAmzSecurityToken, params.SessionToken)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	scope := now.Format(sigV4DateFormat) + "/" + params.Region + "/" + params.Service + "/aws4_request"
+	signature := sigV4Signature(req, params.SecretAccessKey, scope, now.Format(sigV4TimeFormat), headers, payloadHash)
+	req.Header.Set(echo.HeaderAuthorization, sigV4Algorithm+" Credential="+params.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(headers, ";")+", Signature="+signature)
+	return nil
+}
+
+// SigV4Auth settings.
+type SigV4Auth struct {
+	// Credentials maps access key IDs to their secret access keys.
+	Credentials map[string]string
+	// Region and Service expected in the credential scope.
+	Region  string
+	Service string
+	// MaxSkew is the maximum difference allowed between the signing time and the server clock.
+	// Defaults to 5 minutes.
+	MaxSkew time.Duration
+	// MaxBodySize is the maximum number of bytes read from the request body for verifying its digest.
+	// Defaults to 1MB.
+	MaxBodySize int64
+}
+
+// NewSigV4AuthMiddleware only accepts requests signed with AWS Signature Version 4 by one of the configured
+// credentials, such as the ones signed by NewSigV4SigningMiddleware. The host and X-Amz-Date headers must be signed
+// and the body must match the X-Amz-Content-Sha256 header, unsigned payloads are rejected.
+func NewSigV4AuthMiddleware(params SigV4Auth) echo.MiddlewareFunc {
+	if params.MaxSkew <= 0 {
+		params.MaxSkew = 5 * time.Minute
+	}
+	if params.MaxBodySize <= 0 {
+		params.MaxBodySize = 1 << 20
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c Context) error {
+			req := c.Request()
+			auth, ok := parseSigV4Authorization(req.Header.Get(echo.HeaderAuthorization))
+			if !ok {
+				return errUnauthorized
+			}
+			secret, ok := params.Credentials[auth.accessKeyID]
+			if !ok {
+				return errUnauthorized
+			}
+			signed, err := time.Parse(sigV4TimeFormat, req.Header.Get(HeaderAmzDate))
+			if err != nil {
+				return errUnauthorized
+			}
+			if skew := time.Since(signed); skew > params.MaxSkew || skew < -params.MaxSkew {
+				return errUnauthorized
+			}
+			if auth.scope != signed.Format(sigV4DateFormat)+"/"+params.Region+"/"+params.Service+"/aws4_request" {
+				return errUnauthorized
+			}
+			if !slices.Contains(auth.headers, "host") || !slices.Contains(auth.headers, "x-amz-date") {
+				return errUnauthorized
+			}
+
+			body, err := readRequestBody(req, params.MaxBodySize)
+			if err != nil {
+				return err
+			}
+			payloadHash := sha256Hex(body)
+			if req.Header.Get(HeaderAmzContentSHA256) != payloadHash {
+				return errUnauthorized
+			}
+			expected := sigV4Signature(req, secret, auth.scope, req.Header.Get(HeaderAmzDate), auth.headers, payloadHash)
+			if !hmac.Equal([]byte(expected), []byte(auth.signature)) {
+				return errUnauthorized
+			}
+			setIdentity(c, "sigv4:"+auth.accessKeyID)
+			return next(c)
+		}
+	}
+}
+
+type sigV4Authorization struct {
+	accessKeyID string
+	scope       string
+	headers     []string
+	signature   string
+}
+
+// parseSigV4Authorization parses "AWS4-HMAC-SHA256 Credential=<key>/<scope>, SignedHeaders=<h1;h2>, Signature=<hex>".
+func parseSigV4Authorization(header string) (sigV4Authorization, bool) {
+	var auth sigV4Authorization
+	fields, ok := strings.CutPrefix(header, sigV4Algorithm+" ")
+	if !ok {
+		return auth, false
+	}
+	for _, field := range strings.Split(fields, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			auth.accessKeyID, auth.scope, _ = strings.Cut(value, "/")
+		case "SignedHeaders":
+			auth.headers = strings.Split(value, ";")
+		case "Signature":
+			auth.signature = value
+		}
+	}
+	return auth, auth.accessKeyID != "" && auth.scope != "" && len(auth.headers) > 0 && auth.signature != ""
+}
+
+// sigV4Signature computes the hex encoded signature of a request, for the signed headers sorted and in lowercase.
+func sigV4Signature(req *http.Request, secret, scope, amzDate string, headers []string, payloadHash string) string {
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(sigV4CanonicalPath(req.URL.Path) + "\n")
+	canonical.WriteString(sigV4CanonicalQuery(req.URL.Query()) + "\n")
+	for _, name := range headers {
+		canonical.WriteString(name + ":" + strings.Join(strings.Fields(headerValue(req, name)), " ") + "\n")
+	}
+	canonical.WriteString("\n" + strings.Join(headers, ";") + "\n" + payloadHash)
+
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical.String()))
+
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func sigV4CanonicalPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = sigV4Escape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int { return strings.Compare(sigV4Escape(a), sigV4Escape(b)) })
+	var params []string
+	for _, name := range names {
+		values := slices.Clone(query[name])
+		for i := range values {
+			values[i] = sigV4Escape(values[i])
+		}
+		slices.Sort(values)
+		for _, value := range values {
+			params = append(params, sigV4Escape(name)+"="+value)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// sigV4Escape percent-encodes everything but the unreserved characters.
+func sigV4Escape(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,97 @@
+package webservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigV4Signature(t *testing.T) {
+	// get-vanilla and get-vanilla-query-order-key-case from the AWS Signature Version 4 test suite
+	for uri, expected := range map[string]string{
+		"/":                             "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		"/?Param2=value2&Param1=value1": "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com"+uri, nil)
+		req.Header.Set(HeaderAmzDate, "20150830T123600Z")
+		signature := sigV4Signature(req, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830/us-east-1/service/aws4_request",
+			"20150830T123600Z", []string{"host", "x-amz-date"}, sha256Hex(nil))
+		assert.Equal(t, expected, signature, uri)
+	}
+}
+
+func TestSigV4Middleware(t *testing.T) {
+	srv := newAuthTestServer(NewSigV4AuthMiddleware(SigV4Auth{
+		Credentials: map[string]string{"AKID": "secret"},
+		Region:      "eu-west-1",
+		Service:     "webhooks",
+	}))
+	params := SigV4{AccessKeyID: "AKID", SecretAccessKey: "secret", Region: "eu-west-1", Service: "webhooks"}
+	signed := func(t *testing.T, params SigV4, now time.Time) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/test?b=2&a=1&a=0", strings.NewReader("payload"))
+		require.NoError(t, params.sign(req, now))
+		return req
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		req := signed(t, params, time.Now())
+		assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+		rec := serve(srv, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "sigv4:AKID", rec.Body.String())
+	})
+
+	t.Run("session token", func(t *testing.T) {
+		withToken := params
+		withToken.SessionToken = "token"
+		req := signed(t, withToken, time.Now())
+		assert.Equal(t, http.StatusOK, serve(srv, req).Code)
+		req = signed(t, withToken, time.Now())
+		req.Header.Set(HeaderAmzSecurityToken, "other")
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := signed(t, params, time.Now())
+		req.Body = http.NoBody
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("tampered query", func(t *testing.T) {
+		req := signed(t, params, time.Now())
+		req.URL.RawQuery = "a=1"
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, req).Code)
+	})
+
+	t.Run("wrong scope", func(t *testing.T) {
+		other := params
+		other.Region = "us-east-1"
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, signed(t, other, time.Now())).Code)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		other := params
+		other.SecretAccessKey = "other"
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, signed(t, other, time.Now())).Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(srv, signed(t, params, time.Now().Add(-time.Hour))).Code)
+	})
+
+	t.Run("client", func(t *testing.T) {
+		api := httptest.NewServer(srv.Echo)
+		defer api.Close()
+		cli := NewCustomClient(api.URL, ClientOptions{Middlewares: []RequestMiddleware{NewSigV4SigningMiddleware(params)}})
+		status, body, err := cli.Request(context.Background(), http.MethodPost, "/test?x=a%20b", []byte("payload"))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "sigv4:AKID", string(body))
+	})
+}
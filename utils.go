@@ -1,9 +1,13 @@
 package webservice
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"os/user"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -77,3 +81,51 @@ func combineURL(base string, endpoint string) string {
 	}
 	return base + "/" + endpoint
 }
+
+// timeoutUnits used for encoding timeouts in the gRPC timeout format, from the most to the least precise.
+var timeoutUnits = []struct {
+	unit byte
+	d    time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// formatTimeout encodes a timeout in the gRPC timeout format, at most 8 digits followed by a unit, e.g. "250m".
+// Uses the most precise unit which fits the 8 digits, rounding up so the timeout is never shortened.
+func formatTimeout(timeout time.Duration) string {
+	if timeout <= 0 {
+		return "0n"
+	}
+	for _, u := range timeoutUnits {
+		value := (timeout + u.d - 1) / u.d
+		if value < 1e8 {
+			return strconv.FormatInt(int64(value), 10) + string(u.unit)
+		}
+	}
+	return "99999999H"
+}
+
+// parseTimeout decodes a timeout in the gRPC timeout format.
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	for _, u := range timeoutUnits {
+		if u.unit == value[len(value)-1] {
+			if n > int64(math.MaxInt64/u.d) {
+				return math.MaxInt64, nil
+			}
+			return time.Duration(n) * u.d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timeout unit %q", value)
+}
@@ -5,6 +5,7 @@ import (
 	"os/user"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -60,3 +61,29 @@ func BenchmarkCombineURL(b *testing.B) {
 		}
 	})
 }
+
+func TestTimeoutFormat(t *testing.T) {
+	assert.Equal(t, "25000000n", formatTimeout(25*time.Millisecond))
+	assert.Equal(t, "250000u", formatTimeout(250*time.Millisecond))
+	assert.Equal(t, "90000000u", formatTimeout(90*time.Second))
+	assert.Equal(t, "2000001u", formatTimeout(2*time.Second+time.Nanosecond), "rounds up")
+	assert.Equal(t, "0n", formatTimeout(-time.Second))
+
+	for _, d := range []time.Duration{time.Nanosecond, 25 * time.Millisecond, 90 * time.Second, 48 * time.Hour} {
+		parsed, err := parseTimeout(formatTimeout(d))
+		assert.NoError(t, err)
+		assert.Equal(t, d, parsed)
+	}
+
+	parsed, err := parseTimeout("5S")
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, parsed)
+	parsed, err = parseTimeout("1H")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, parsed)
+
+	for _, value := range []string{"", "5", "S", "5s", "-5S", "123456789S"} {
+		_, err = parseTimeout(value)
+		assert.Error(t, err, value)
+	}
+}